@@ -2,136 +2,350 @@ package qrn
 
 import (
 	"context"
-	"database/sql"
 	"fmt"
 	"sync/atomic"
 	"time"
 
 	_ "github.com/go-sql-driver/mysql"
 	_ "github.com/jackc/pgx/v4/stdlib"
+	_ "modernc.org/sqlite"
 )
 
 const AgentInterruptPeriod = 1 * time.Second
-
-type ConnInfo struct {
-	Driver       string
-	DSN          string
-	MaxIdleConns int
-}
+const ReconnectBackoffMin = 100 * time.Millisecond
+const ReconnectBackoffMax = 5 * time.Second
+const MarkerTimeout = 10 * time.Second
 
 type Agent struct {
-	Id       int
-	ConnInfo *ConnInfo
-	DB       *sql.DB
-	Data     *Data
-	Logger   *Logger
-	Token    string
+	Id              int
+	Target          int
+	ConnInfo        *ConnInfo
+	ReplicaConnInfo *ConnInfo
+	Session         *Session
+	Replica         *Session
+	ShadowConnInfo  *ConnInfo
+	Shadow          *Session
+	ShadowLogger    *ShadowLogger
+	ShadowWrites    bool
+	Tracer          *Tracer
+	Data            *Data
+	Logger          *Logger
+	Token           string
+	Reconnect       bool
+	QueryTimeout    time.Duration
+	Queries         int64
+	Errors          int64
+	state           int32
+	cancel          context.CancelFunc
+	queryCtx        context.Context
+	inTx            bool
+	fingerprints    map[string]string
 }
 
 func (agent *Agent) Prepare(preQueries []string) error {
-	db, err := sql.Open(agent.ConnInfo.Driver, agent.ConnInfo.DSN)
+	agent.Session = &Session{ConnInfo: agent.ConnInfo}
+	err := agent.Session.Open(preQueries)
 
 	if err != nil {
 		return err
 	}
 
-	db.SetConnMaxLifetime(0)
-	db.SetMaxIdleConns(0)
+	if agent.ReplicaConnInfo != nil {
+		agent.Replica = &Session{ConnInfo: agent.ReplicaConnInfo}
+		err = agent.Replica.Open(preQueries)
 
-	err = db.Ping()
+		if err != nil {
+			return err
+		}
+	}
 
-	if err != nil {
-		return err
+	if agent.ShadowConnInfo != nil {
+		agent.Shadow = &Session{ConnInfo: agent.ShadowConnInfo}
+		err = agent.Shadow.Open(preQueries)
 	}
 
-	db.SetMaxIdleConns(agent.ConnInfo.MaxIdleConns)
+	return err
+}
 
-	if err != nil {
-		return err
+// route returns the session for the query.
+// If a replica is set, read-only queries outside transactions are routed to it.
+func (agent *Agent) route(query string) (*Session, string) {
+	if agent.Replica == nil {
+		return agent.Session, ""
 	}
 
-	for _, q := range preQueries {
-		_, err = db.Exec(q)
+	if isTxBegin(query) {
+		agent.inTx = true
+	} else if isTxEnd(query) {
+		agent.inTx = false
+		return agent.Session, RoutePrimary
+	}
 
-		if err != nil {
-			return err
-		}
+	if !agent.inTx && IsReadOnly(query) {
+		return agent.Replica, RouteReplica
 	}
 
-	agent.DB = db
+	return agent.Session, RoutePrimary
+}
 
-	return nil
+// fingerprint returns the fingerprint of the query.
+// Fingerprints are cached because the same statements are executed repeatedly.
+func (agent *Agent) fingerprint(query string) string {
+	if fp, ok := agent.fingerprints[query]; ok {
+		return fp
+	}
+
+	fp := Fingerprint(agent.ConnInfo.Driver, query)
+
+	if agent.fingerprints == nil {
+		agent.fingerprints = map[string]string{}
+	}
+
+	if len(agent.fingerprints) < FingerprintCacheSize {
+		agent.fingerprints[query] = fp
+	}
+
+	return fp
+}
+
+func (agent *Agent) sessions() []*Session {
+	sessions := []*Session{}
+
+	for _, s := range []*Session{agent.Session, agent.Replica, agent.Shadow} {
+		if s != nil {
+			sessions = append(sessions, s)
+		}
+	}
+
+	return sessions
 }
 
 func (agent *Agent) Run(ctx context.Context, recorder *Recorder) error {
 	ticker := time.NewTicker(AgentInterruptPeriod)
 	defer ticker.Stop()
 	responseTimes := []DataPoint{}
+	queryCtx := agent.queryCtx
+	var outage *Outage
+
+	for _, s := range agent.sessions() {
+		s.setRecorder(recorder)
+	}
+
+	// NOTE: queryCtx cancels in-flight queries. It outlives ctx only when the run is interrupted, so that the agent can stop gracefully
+	if queryCtx == nil {
+		queryCtx = ctx
+	}
 
-	_, err := agent.DB.Exec(fmt.Sprintf("SELECT 'agent(%d) start: token=%s'", agent.Id, agent.Token))
+	_, err := agent.Query(context.Background(), fmt.Sprintf("SELECT 'agent(%d) start: token=%s'", agent.Id, agent.Token), MarkerTimeout)
 
 	if err != nil {
 		return err
 	}
 
-	loopCount, err := agent.Data.EachLine(func(query string) (bool, error) {
+	loopCount, err := agent.Data.EachLine(func(query string, timeout time.Duration) (bool, error) {
 		select {
 		case <-ctx.Done():
 			return false, nil
 		case <-ticker.C:
 			recorder.Add(responseTimes)
-			responseTimes = responseTimes[:0]
+			responseTimes = make([]DataPoint, 0, len(responseTimes))
 		default:
 			// nothing to do
 		}
 
-		rt, err := agent.Query(ctx, query)
+		if agent.Data.Throttle != nil {
+			agent.Data.Throttle.Wait(ctx)
 
-		if err != nil {
-			select {
-			case <-ctx.Done():
+			if ctx.Err() != nil {
 				return false, nil
-			default:
-				return false, err
 			}
 		}
 
+		if timeout == 0 {
+			timeout = agent.QueryTimeout
+		}
+
+		session, route := agent.route(query)
+		span := agent.Tracer.Sample()
+		stmt := query
+
+		if span != nil && agent.Tracer.Comment {
+			stmt = span.Annotate(query)
+		}
+
+		var rt time.Duration
+		var rows int64
+		var err error
+		atomic.AddInt64(&recorder.InFlight, 1)
+
+		// writes are not executed on the shadow unless allowed, so that it is not modified by accident
+		if agent.Shadow != nil && (agent.ShadowWrites || IsReadOnly(query)) {
+			var result *ResultSet
+			rt, result, err = session.Fetch(queryCtx, stmt, timeout)
+
+			if result != nil {
+				rows = int64(result.Rows)
+			}
+
+			if queryCtx.Err() == nil {
+				responseTimes = append(responseTimes, agent.verify(queryCtx, query, timeout, result, err))
+			}
+		} else {
+			rt, rows, err = session.Exec(queryCtx, stmt, timeout)
+		}
+
+		atomic.AddInt64(&recorder.InFlight, -1)
+
 		tm := time.Now()
 
+		if span != nil && queryCtx.Err() == nil {
+			agent.trace(span, session, stmt, query, route, rows, err, tm.Add(-rt), tm)
+		}
+
+		if err != nil {
+			if queryCtx.Err() != nil {
+				// the query is cancelled by the end of the run or the removal of the agent
+				return false, nil
+			}
+
+			atomic.AddInt64(&agent.Errors, 1)
+			class := ClassifyError(err)
+			recorder.SampleError(class, err)
+			timedOut := timeout > 0 && class == ErrorClassTimeout
+
+			if timedOut && recorder.ClampTimeouts && rt > timeout {
+				rt = timeout
+			}
+
+			responseTimes = append(responseTimes, DataPoint{
+				Time:         tm,
+				ResponseTime: rt,
+				Fingerprint:  agent.fingerprint(query),
+				Error:        class,
+				Agent:        agent.Id,
+				Target:       agent.Target,
+				Route:        route,
+			})
+
+			if timedOut {
+				// timed-out queries are counted separately and do not stop the agent
+				return true, nil
+			}
+
+			if agent.Reconnect && IsReconnectable(class) {
+				if outage == nil {
+					outage = &Outage{
+						Agent:   agent.Id,
+						Started: tm.Add(-rt),
+					}
+				}
+
+				outage.Errors++
+				agent.reconnect(ctx, queryCtx, session)
+
+				return true, nil
+			}
+
+			return false, err
+		}
+
+		if outage != nil {
+			outage.recover(tm)
+			recorder.AddOutage(outage)
+			outage = nil
+		}
+
+		atomic.AddInt64(&agent.Queries, 1)
 		agent.Logger.Log(query, rt, tm)
 
 		responseTimes = append(responseTimes, DataPoint{
 			Time:         tm,
 			ResponseTime: rt,
+			Fingerprint:  agent.fingerprint(query),
+			Agent:        agent.Id,
+			Target:       agent.Target,
+			Route:        route,
+			Rows:         rows,
 		})
 
 		return true, nil
 	})
 
+	recorder.Add(responseTimes)
+
+	if outage != nil {
+		// not recovered until the end of the run
+		outage.Duration = time.Since(outage.Started)
+		recorder.AddOutage(outage)
+	}
+
 	if err != nil {
 		return err
 	}
 
-	recorder.Add(responseTimes)
 	atomic.StoreInt64(&recorder.LoopCount, loopCount)
 
-	_, err = agent.DB.Exec(fmt.Sprintf("SELECT 'agent(%d) end: token=%s'", agent.Id, agent.Token))
+	_, err = agent.Query(context.Background(), fmt.Sprintf("SELECT 'agent(%d) end: token=%s'", agent.Id, agent.Token), MarkerTimeout)
+
+	if err != nil && agent.Reconnect && IsConnectionError(err) {
+		err = nil
+	}
 
 	return err
 }
 
-func (agent *Agent) Query(ctx context.Context, query string) (time.Duration, error) {
-	start := time.Now()
-	_, err := agent.DB.ExecContext(ctx, query)
-	end := time.Now()
+func (agent *Agent) trace(span *Span, session *Session, stmt string, query string, route string, rows int64, err error, start time.Time, end time.Time) {
+	span.Start = start
+	span.End = end
+	span.Statement = stmt
+	span.Fingerprint = agent.fingerprint(query)
+	span.Agent = agent.Id
+	span.System = dbSystem(session.ConnInfo.Driver)
+	span.Host = session.ConnInfo.Host
+	span.Route = route
+	span.Rows = rows
 
 	if err != nil {
-		return 0, err
+		span.Error = err.Error()
+		span.ErrorClass = ClassifyError(err)
+	}
+
+	agent.Tracer.Add(span)
+}
+
+// reconnect waits with exponential backoff until a new connection is established and the pre-queries succeed.
+func (agent *Agent) reconnect(ctx context.Context, queryCtx context.Context, session *Session) {
+	backoff := ReconnectBackoffMin
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+
+		session.disconnect()
+
+		if session.connect(queryCtx) == nil {
+			return
+		}
+
+		backoff *= 2
+
+		if backoff > ReconnectBackoffMax {
+			backoff = ReconnectBackoffMax
+		}
 	}
+}
 
-	return end.Sub(start), nil
+// Query executes the query on the primary regardless of replicas, so that the start/end markers are logged where the writes are.
+func (agent *Agent) Query(ctx context.Context, query string, timeout time.Duration) (time.Duration, error) {
+	rt, _, err := agent.Session.Exec(ctx, query, timeout)
+	return rt, err
 }
 
 func (agent *Agent) Close() {
-	agent.DB.Close()
+	for _, s := range agent.sessions() {
+		s.Close()
+	}
 }
@@ -0,0 +1,197 @@
+package qrn
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var assertionRegexp = regexp.MustCompile(`^\s*([a-z0-9]+)\s*(<=|>=|==|<|>)\s*(\S+)\s*$`)
+
+var latencyMetrics = map[string]func(*RecordReport) time.Duration{
+	"p50":  func(r *RecordReport) time.Duration { return r.Response.Time.P50 },
+	"p75":  func(r *RecordReport) time.Duration { return r.Response.Time.P75 },
+	"p95":  func(r *RecordReport) time.Duration { return r.Response.Time.P95 },
+	"p99":  func(r *RecordReport) time.Duration { return r.Response.Time.P99 },
+	"p999": func(r *RecordReport) time.Duration { return r.Response.Time.P999 },
+	"avg":  func(r *RecordReport) time.Duration { return r.Response.Time.Avg },
+	"min":  func(r *RecordReport) time.Duration { return r.Response.Time.Min },
+	"max":  func(r *RecordReport) time.Duration { return r.Response.Time.Max },
+}
+
+var qpsMetrics = map[string]func(*RecordReport) float64{
+	"qps":       func(r *RecordReport) float64 { return r.QPS },
+	"maxqps":    func(r *RecordReport) float64 { return r.MaxQPS },
+	"minqps":    func(r *RecordReport) float64 { return r.MinQPS },
+	"medianqps": func(r *RecordReport) float64 { return r.MedianQPS },
+}
+
+// countMetrics return the count and the rate of failed queries. A timed-out query is counted only in "timeouts".
+var countMetrics = map[string]func(*RecordReport) (int, float64){
+	"errors":   func(r *RecordReport) (int, float64) { return r.Errors, r.ErrorRate },
+	"timeouts": func(r *RecordReport) (int, float64) { return r.Timeouts, r.TimeoutRate },
+}
+
+// Assertion is a threshold on a report metric, such as "p99<20ms", "errors<0.1%" or "qps>=1000".
+type Assertion struct {
+	Expr    string
+	Metric  string
+	Op      string
+	Value   float64
+	Percent bool
+}
+
+type AssertionResult struct {
+	Assertion string
+	Actual    string
+	Passed    bool
+}
+
+type Assertions []*Assertion
+
+func (assertions *Assertions) String() string {
+	exprs := make([]string, len(*assertions))
+
+	for i, v := range *assertions {
+		exprs[i] = v.Expr
+	}
+
+	return strings.Join(exprs, ",")
+}
+
+func (assertions *Assertions) Set(expr string) error {
+	assertion, err := ParseAssertion(expr)
+
+	if err != nil {
+		return err
+	}
+
+	*assertions = append(*assertions, assertion)
+	return nil
+}
+
+func ParseAssertion(expr string) (*Assertion, error) {
+	m := assertionRegexp.FindStringSubmatch(strings.ToLower(expr))
+
+	if m == nil {
+		return nil, fmt.Errorf("invalid assertion: %s", expr)
+	}
+
+	assertion := &Assertion{
+		Expr:   expr,
+		Metric: m[1],
+		Op:     m[2],
+	}
+
+	value := m[3]
+
+	if _, ok := latencyMetrics[assertion.Metric]; ok {
+		d, err := time.ParseDuration(value)
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid assertion: %s: %w", expr, err)
+		}
+
+		assertion.Value = float64(d)
+		return assertion, nil
+	}
+
+	_, isQPS := qpsMetrics[assertion.Metric]
+	_, isCount := countMetrics[assertion.Metric]
+
+	if !isQPS && !isCount {
+		return nil, fmt.Errorf("invalid assertion: %s: unknown metric '%s'", expr, assertion.Metric)
+	}
+
+	if isCount && strings.HasSuffix(value, "%") {
+		assertion.Percent = true
+		value = strings.TrimSuffix(value, "%")
+	}
+
+	v, err := strconv.ParseFloat(value, 64)
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid assertion: %s: %w", expr, err)
+	}
+
+	assertion.Value = v
+
+	return assertion, nil
+}
+
+func (assertion *Assertion) isLatency() bool {
+	_, ok := latencyMetrics[assertion.Metric]
+	return ok
+}
+
+// Continuous reports whether the assertion is meaningful during a run.
+// QPS assertions are only checked at the end because the rate is low while agents are warming up.
+func (assertion *Assertion) Continuous() bool {
+	_, ok := qpsMetrics[assertion.Metric]
+	return !ok
+}
+
+func (assertion *Assertion) Check(report *RecordReport) *AssertionResult {
+	var actual float64
+	var actualStr string
+
+	if f, ok := latencyMetrics[assertion.Metric]; ok {
+		if report.Response == nil || report.Response.Count == 0 {
+			return &AssertionResult{
+				Assertion: assertion.Expr,
+				Actual:    "N/A",
+				Passed:    false,
+			}
+		}
+
+		d := f(report)
+		actual = float64(d)
+		actualStr = d.String()
+	} else if f, ok := qpsMetrics[assertion.Metric]; ok {
+		actual = f(report)
+		actualStr = strconv.FormatFloat(actual, 'f', -1, 64)
+	} else if count, rate := countMetrics[assertion.Metric](report); assertion.Percent {
+		actual = rate * 100
+		actualStr = strconv.FormatFloat(actual, 'f', -1, 64) + "%"
+	} else {
+		actual = float64(count)
+		actualStr = strconv.Itoa(count)
+	}
+
+	var passed bool
+
+	switch assertion.Op {
+	case "<":
+		passed = actual < assertion.Value
+	case "<=":
+		passed = actual <= assertion.Value
+	case ">":
+		passed = actual > assertion.Value
+	case ">=":
+		passed = actual >= assertion.Value
+	case "==":
+		passed = actual == assertion.Value
+	}
+
+	return &AssertionResult{
+		Assertion: assertion.Expr,
+		Actual:    actualStr,
+		Passed:    passed,
+	}
+}
+
+func (assertions Assertions) Check(report *RecordReport) []*AssertionResult {
+	if len(assertions) == 0 {
+		return nil
+	}
+
+	results := make([]*AssertionResult, len(assertions))
+
+	for i, v := range assertions {
+		results[i] = v.Check(report)
+	}
+
+	return results
+}
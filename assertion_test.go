@@ -0,0 +1,112 @@
+package qrn
+
+import (
+	"testing"
+	"time"
+
+	"github.com/winebarrel/tachymeter"
+)
+
+func TestParseAssertion(t *testing.T) {
+	tests := []struct {
+		expr     string
+		expected *Assertion
+		err      bool
+	}{
+		{"p99<20ms", &Assertion{Metric: "p99", Op: "<", Value: float64(20 * time.Millisecond)}, false},
+		{" P999 >= 1.5s ", &Assertion{Metric: "p999", Op: ">=", Value: float64(1500 * time.Millisecond)}, false},
+		{"max<=500us", &Assertion{Metric: "max", Op: "<=", Value: float64(500 * time.Microsecond)}, false},
+		{"qps>=1000", &Assertion{Metric: "qps", Op: ">=", Value: 1000}, false},
+		{"minqps>10.5", &Assertion{Metric: "minqps", Op: ">", Value: 10.5}, false},
+		{"errors==0", &Assertion{Metric: "errors", Op: "==", Value: 0}, false},
+		{"errors<0.1%", &Assertion{Metric: "errors", Op: "<", Value: 0.1, Percent: true}, false},
+		{"timeouts<1%", &Assertion{Metric: "timeouts", Op: "<", Value: 1, Percent: true}, false},
+		{"timeouts<=3", &Assertion{Metric: "timeouts", Op: "<=", Value: 3}, false},
+		{"p99<20", nil, true},
+		{"qps>=1%", nil, true},
+		{"qps>=abc", nil, true},
+		{"p42<1ms", nil, true},
+		{"p99=1ms", nil, true},
+		{"p99", nil, true},
+		{"", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			assertion, err := ParseAssertion(tt.expr)
+
+			if tt.err {
+				if err == nil {
+					t.Fatalf("expected an error, got %+v", assertion)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			tt.expected.Expr = tt.expr
+
+			if *assertion != *tt.expected {
+				t.Errorf("expected %+v, got %+v", tt.expected, assertion)
+			}
+		})
+	}
+}
+
+func TestAssertionCheck(t *testing.T) {
+	response := &tachymeter.Metrics{Count: 100}
+	response.Time.P99 = 15 * time.Millisecond
+
+	report := &RecordReport{
+		Queries:  990,
+		QPS:      1200,
+		Response: response,
+		Errors:   5,
+		Timeouts: 5,
+	}
+
+	report.calcErrorRate()
+
+	tests := []struct {
+		expr   string
+		actual string
+		passed bool
+	}{
+		{"p99<20ms", "15ms", true},
+		{"p99<15ms", "15ms", false},
+		{"qps>=1000", "1200", true},
+		{"errors<=5", "5", true},
+		{"errors<0.5%", "0.5%", false},
+		{"errors<=0.5%", "0.5%", true},
+		{"timeouts==5", "5", true},
+		{"timeouts<0.1%", "0.5%", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			assertion, err := ParseAssertion(tt.expr)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			result := assertion.Check(report)
+
+			if result.Actual != tt.actual || result.Passed != tt.passed {
+				t.Errorf("expected %s (passed=%v), got %s (passed=%v)", tt.actual, tt.passed, result.Actual, result.Passed)
+			}
+		})
+	}
+
+	t.Run("no latency", func(t *testing.T) {
+		assertion, _ := ParseAssertion("p99<20ms")
+		result := assertion.Check(&RecordReport{})
+
+		if result.Actual != "N/A" || result.Passed {
+			t.Errorf("expected N/A and failed, got %+v", result)
+		}
+	})
+}
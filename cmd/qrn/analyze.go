@@ -0,0 +1,164 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"qrn"
+	"strings"
+	"time"
+)
+
+// analyzeMain runs 'qrn analyze DUMP' and returns the exit code.
+func analyzeMain(args []string) int {
+	fs := flag.NewFlagSet("analyze", flag.ExitOnError)
+
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage of %s analyze [OPTIONS] SAMPLES:\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+
+	flags := &Flags{}
+	options := &qrn.ReplayOptions{}
+	fs.IntVar(&options.HBins, "hbins", DefaultHBins, "histogram bins")
+	hinterval := fs.String("hinterval", "0", "histogram interval")
+	timeoutLatency := fs.String("timeout-latency", "exclude", "how timed-out queries are treated in latency metrics (exclude/clamp)")
+	from := fs.String("from", "0", "start of the analyzed window from the start of the run")
+	to := fs.String("to", "0", "end of the analyzed window from the start of the run. zero is the end of the run")
+	fs.Var(&options.Assertions, "assert", "assertion checked against the recomputed report (e.g. 'p99<20ms', 'errors<0.1%', 'qps>=1000')")
+	timeSeries := fs.String("timeseries", "", "file path of the recomputed time series (.csv or .jsonl)")
+	interval := fs.String("timeseries-interval", qrn.DefaultTimeSeriesInterval.String(), "interval of the time series")
+	hlog := fs.String("hlog", "", "file path of the latency histogram of each interval in the HdrHistogram interval log format (.hlog)")
+	hlogInterval := fs.String("hlog-interval", qrn.DefaultHistogramLogInterval.String(), "interval of '-hlog'")
+	hlogDigits := fs.Int("hlog-digits", qrn.DefaultHistogramLogDigits, "number of significant value digits of '-hlog' (1-5)")
+	fs.BoolVar(&flags.Histogram, "histogram", false, "show histogram")
+	fs.StringVar(&flags.HTMLReport, "html-report", "", "file path of the self-contained HTML report")
+	fs.StringVar(&flags.ReportFormat, "report-format", qrn.ReportFormatJSON, "format of the report (json/markdown/csv/junit)")
+	fs.StringVar(&flags.ReportOutput, "report-output", "", "file path to write the report to instead of stdout. csv rows are appended")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+
+	fail := func(msg string) int {
+		fmt.Fprintln(os.Stderr, msg)
+		return 1
+	}
+
+	if hi, err := time.ParseDuration(*hinterval); err != nil {
+		return fail(err.Error())
+	} else {
+		options.HInterval = hi
+	}
+
+	if f, err := time.ParseDuration(*from); err != nil {
+		return fail(err.Error())
+	} else {
+		options.From = f
+	}
+
+	if t, err := time.ParseDuration(*to); err != nil {
+		return fail(err.Error())
+	} else {
+		options.To = t
+	}
+
+	switch *timeoutLatency {
+	case "exclude":
+		options.ClampTimeouts = false
+	case "clamp":
+		options.ClampTimeouts = true
+	default:
+		return fail("'-timeout-latency' must be 'exclude' or 'clamp'")
+	}
+
+	if options.From < 0 || options.To < 0 {
+		return fail("'-from' and '-to' must be >= 0")
+	} else if options.To > 0 && options.To <= options.From {
+		return fail("'-to' must be > '-from'")
+	}
+
+	switch flags.ReportFormat {
+	case qrn.ReportFormatJSON, qrn.ReportFormatMarkdown, qrn.ReportFormatCSV, qrn.ReportFormatJUnit:
+		// nothing to do
+	default:
+		return fail("'-report-format' must be 'json', 'markdown', 'csv' or 'junit'")
+	}
+
+	if *timeSeries != "" {
+		ti, err := time.ParseDuration(*interval)
+
+		if err != nil {
+			return fail(err.Error())
+		} else if ti <= 0 {
+			return fail("'-timeseries-interval' must be > 0")
+		}
+
+		format := qrn.TimeSeriesJSONL
+
+		if strings.HasSuffix(*timeSeries, ".csv") {
+			format = qrn.TimeSeriesCSV
+		}
+
+		file, err := os.OpenFile(*timeSeries, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+
+		if err != nil {
+			return fail(err.Error())
+		}
+
+		defer file.Close()
+		options.TimeSeries = qrn.NewTimeSeries(file, format, ti)
+	}
+
+	if *hlog != "" {
+		hi, err := time.ParseDuration(*hlogInterval)
+
+		if err != nil {
+			return fail(err.Error())
+		} else if hi <= 0 {
+			return fail("'-hlog-interval' must be > 0")
+		}
+
+		if *hlogDigits < 1 || *hlogDigits > 5 {
+			return fail("'-hlog-digits' must be >= 1 and <= 5")
+		}
+
+		file, err := os.OpenFile(*hlog, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+
+		if err != nil {
+			return fail(err.Error())
+		}
+
+		defer file.Close()
+		options.HistogramLog = qrn.NewHistogramLog(file, hi, *hlogDigits)
+	}
+
+	recorder, err := qrn.ReplaySamples(fs.Arg(0), options)
+
+	if err != nil {
+		return fail(err.Error())
+	}
+
+	if options.HistogramLog != nil {
+		err := options.HistogramLog.Close()
+
+		if err != nil {
+			return fail(err.Error())
+		}
+	}
+
+	report := recorder.Report()
+	err = showResult(flags, recorder, report)
+
+	if err != nil {
+		return fail(err.Error())
+	}
+
+	if report.AssertionFailed() {
+		return ExitAssertionFailed
+	}
+
+	return 0
+}
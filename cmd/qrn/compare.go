@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"qrn"
+	"strings"
+	"time"
+)
+
+const DefaultCompareTop = 10
+
+// ExitRegression is the exit code of 'qrn compare' when a metric regressed.
+const ExitRegression = 3
+
+// compareMain runs 'qrn compare BASE NEW' and returns the exit code.
+func compareMain(args []string) int {
+	fs := flag.NewFlagSet("compare", flag.ExitOnError)
+
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage of %s compare [OPTIONS] BASE_REPORT NEW_REPORT:\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+
+	tolerances := qrn.DefaultTolerances
+	qpsTolerance := fs.Float64("qps-tolerance", tolerances.QPS*100, "allowed decrease of QPS (%)")
+	latencyTolerance := fs.Float64("latency-tolerance", tolerances.Latency*100, "allowed increase of each latency metric (%)")
+	errorTolerance := fs.Float64("error-tolerance", tolerances.ErrorRate*100, "allowed increase of the error rate (percentage points)")
+	top := fs.Int("top", DefaultCompareTop, "number of regressed query shapes to show")
+	asJSON := fs.Bool("json", false, "output the comparison as JSON")
+	fs.Parse(args)
+
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return 2
+	}
+
+	reports := make([]*qrn.RecordReport, 2)
+
+	for i, path := range fs.Args() {
+		report, err := qrn.LoadRecordReport(path)
+
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+
+		reports[i] = report
+	}
+
+	tolerances = qrn.Tolerances{
+		QPS:       *qpsTolerance / 100,
+		Latency:   *latencyTolerance / 100,
+		ErrorRate: *errorTolerance / 100,
+	}
+
+	cmp := qrn.CompareReports(reports[0], reports[1], tolerances)
+
+	if len(cmp.Fingerprints) > *top {
+		cmp.Fingerprints = cmp.Fingerprints[:*top]
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetEscapeHTML(false)
+		enc.SetIndent("", "  ")
+		enc.Encode(cmp)
+	} else {
+		printComparison(os.Stdout, fs.Arg(0), fs.Arg(1), cmp)
+	}
+
+	if cmp.Regressed() {
+		return ExitRegression
+	}
+
+	return 0
+}
+
+func printComparison(w io.Writer, base string, new string, cmp *qrn.RegressionReport) {
+	regressed := map[string]bool{}
+
+	for _, name := range cmp.Regressions {
+		regressed[name] = true
+	}
+
+	mark := func(name string) string {
+		if regressed[name] {
+			return "  REGRESSION"
+		}
+
+		return ""
+	}
+
+	fmt.Fprintf(w, "base: %s\nnew:  %s\n\n", base, new)
+	fmt.Fprintf(w, "%-7s %14s %14s %9s\n", "", "base", "new", "change")
+
+	fmt.Fprintf(w, "%-7s %14.1f %14.1f %+8.1f%%%s\n", "qps", cmp.QPS.A, cmp.QPS.B, cmp.QPS.Change*100, mark("qps"))
+
+	fmt.Fprintf(w, "%-7s %13.3f%% %13.3f%% %+7.3fpt%s\n", "errors", cmp.ErrorRate.A*100, cmp.ErrorRate.B*100, cmp.ErrorRate.Diff*100, mark("errors"))
+
+	for _, name := range qrn.RegressionLatencies {
+		d := cmp.Latency[name]
+		fmt.Fprintf(w, "%-7s %14s %14s %+8.1f%%%s\n", name, d.A.Round(time.Microsecond), d.B.Round(time.Microsecond), d.Change*100, mark(name))
+	}
+
+	if len(cmp.Fingerprints) > 0 {
+		fmt.Fprintf(w, "\nregressed queries (p99):\n")
+
+		for _, d := range cmp.Fingerprints {
+			p99 := d.Latency["p99"]
+			fmt.Fprintf(w, "%+8.1f%% %12s %12s  %s\n", p99.Change*100, p99.A.Round(time.Microsecond), p99.B.Round(time.Microsecond), strings.Join(strings.Fields(d.Fingerprint), " "))
+		}
+	}
+
+	if len(cmp.Regressions) > 0 {
+		fmt.Fprintf(w, "\nregressed: %s\n", strings.Join(cmp.Regressions, ", "))
+	}
+}
@@ -16,13 +16,32 @@ var version string
 const DefaultTime = 60
 const DefaultJsonKey = "query"
 const DefaultHBins = 10
+const DefaultPushPrefix = "qrn"
 
 type Flags struct {
-	Time        time.Duration
-	Histogram   bool
-	HTML        bool
-	Query       string
-	TaskOptions *qrn.TaskOptions
+	Time               time.Duration
+	Histogram          bool
+	HTML               bool
+	HTMLReport         string
+	TUI                bool
+	Query              string
+	ReportFormat       string
+	ReportOutput       string
+	Control            string
+	MetricsListen      string
+	Push               string
+	PushFormat         string
+	PushPrefix         string
+	PushTags           qrn.Strings
+	PushInterval       time.Duration
+	TimeSeriesInterval time.Duration
+	ShadowLog          *os.File
+	TimeSeries         *os.File
+	HistogramLog       *os.File
+	Samples            *os.File
+	SampleWriter       *qrn.SampleWriter
+	TraceFile          *os.File
+	TaskOptions        *qrn.TaskOptions
 }
 
 type xBool struct {
@@ -52,8 +71,15 @@ func parseFlags() (flags *Flags) {
 
 	var random xBool
 
-	flag.StringVar(&flags.TaskOptions.Driver, "driver", "", "database driver")
-	flag.StringVar(&flags.TaskOptions.DSN, "dsn", "", "data source name")
+	flag.StringVar(&flags.TaskOptions.Driver, "driver", "", "database driver (mysql/pgx/sqlite/fake). detected from each DSN if omitted")
+	flag.Var(&flags.TaskOptions.DSNs, "dsn", "data source name. agents are distributed across multiple DSNs")
+	flag.Var(&flags.TaskOptions.ReplicaDSNs, "replica-dsn", "data source name of a read replica. read-only queries outside transactions are routed to it")
+	flag.StringVar(&flags.TaskOptions.ShadowDSN, "shadow-dsn", "", "data source name of a shadow database. read-only queries are also executed on it and the results are compared")
+	shadowLog := flag.String("shadow-log", "", "file path of the mismatch log of '-shadow-dsn' (default stderr)")
+	flag.BoolVar(&flags.TaskOptions.ShadowWrites, "shadow-writes", false, "also execute writes on '-shadow-dsn'. the shadow is modified")
+	flag.Var(&flags.TaskOptions.Weights, "weight", "weight of each DSN for '-balance weighted'")
+	flag.StringVar(&flags.TaskOptions.Balance, "balance", qrn.BalanceRoundRobin, "distribution of agents across DSNs (round-robin/weighted/least-latency)")
+	flag.BoolVar(&flags.TaskOptions.Compare, "compare", false, "run the same workload against two DSNs side by side and output a differential report")
 	flag.IntVar(&flags.TaskOptions.NAgents, "nagents", 0, "number of agents")
 	argTime := flag.Int("time", DefaultTime, "test run time (sec). zero is unlimited")
 	flag.Var(&flags.TaskOptions.Files, "data", "file path of execution queries for each agent")
@@ -64,14 +90,46 @@ func parseFlags() (flags *Flags) {
 	flag.StringVar(&flags.TaskOptions.Key, "key", DefaultJsonKey, "json key of query")
 	flag.BoolVar(&flags.TaskOptions.Loop, "loop", true, "input data loop flag")
 	flag.BoolVar(&flags.TaskOptions.Force, "force", false, "ignore query error")
+	queryTimeout := flag.String("query-timeout", "0", "timeout for each query. zero is unlimited")
+	flag.StringVar(&flags.TaskOptions.TimeoutKey, "timeout-key", "", "json key of query timeout (duration string or milliseconds). disabled if empty")
+	timeoutLatency := flag.String("timeout-latency", "exclude", "how timed-out queries are treated in latency metrics (exclude/clamp)")
+	flag.StringVar(&flags.TaskOptions.ConnMode, "conn-mode", qrn.ConnModePersistent, "when to open a new connection (persistent/query/tx/lifetime)")
+	connLifetime := flag.String("conn-lifetime", "", "connection lifetime distribution for '-conn-mode lifetime' (e.g. '30s', 'uniform:10s:60s', 'exp:30s')")
+	flag.BoolVar(&flags.TaskOptions.Reconnect, "reconnect", false, "retry with backoff on connection errors and measure outages (for failover tests)")
 	flag.Int64Var(&flags.TaskOptions.MaxCount, "maxcount", 0, "maximum number of queries for each agent. zero is unlimited")
 	flag.Var(&random, "random", "randomize the start position of input data")
-	flag.Var(&flags.TaskOptions.PreQueries, "pre-query", "queries to be pre-executed for each agent")
+	flag.Var(&flags.TaskOptions.PreQueries, "pre-query", "queries to be pre-executed on the connection of each agent (re-executed after reconnects)")
 	flag.Int64Var(&flags.TaskOptions.CommitRate, "commit-rate", 0, "commit rate")
+	timeSeries := flag.String("timeseries", "", "file path of the time series of QPS, latency, errors and agents written during the run (.csv or .jsonl)")
+	timeSeriesInterval := flag.String("timeseries-interval", qrn.DefaultTimeSeriesInterval.String(), "interval of the time series")
+	hlog := flag.String("hlog", "", "file path of the latency histogram of each interval in the HdrHistogram interval log format (.hlog)")
+	hlogInterval := flag.String("hlog-interval", qrn.DefaultHistogramLogInterval.String(), "interval of '-hlog'")
+	hlogDigits := flag.Int("hlog-digits", qrn.DefaultHistogramLogDigits, "number of significant value digits of '-hlog' (1-5)")
+	samples := flag.String("samples", "", "file path to write every sample to for 'qrn analyze'")
 	flag.IntVar(&flags.TaskOptions.HBins, "hbins", DefaultHBins, "histogram bins")
 	hinterval := flag.String("hinterval", "0", "histogram interval")
+	flag.IntVar(&flags.TaskOptions.RampStep, "ramp-step", 0, "number of agents added (or removed if negative) at each ramp interval")
+	rampInterval := flag.String("ramp-interval", "0", "interval of agent count changes")
+	flag.IntVar(&flags.TaskOptions.RampLimit, "ramp-limit", 0, "number of agents at which the ramp stops")
+	flag.Var(&flags.TaskOptions.Assertions, "assert", "assertion checked at the end of the run (e.g. 'p99<20ms', 'errors<0.1%', 'qps>=1000')")
+	assertInterval := flag.String("assert-interval", "0", "interval of assertion checks during the run. the run is aborted at the first failure. zero is disabled")
+	flag.StringVar(&flags.MetricsListen, "metrics-listen", "", "listen address of the Prometheus metrics endpoint (e.g. ':9100')")
+	flag.StringVar(&flags.Push, "push", "", "UDP address to push the metrics of each interval to (e.g. 'localhost:8125')")
+	flag.StringVar(&flags.PushFormat, "push-format", qrn.PushFormatStatsD, "format of pushed metrics (statsd/dogstatsd/influx)")
+	flag.StringVar(&flags.PushPrefix, "push-prefix", DefaultPushPrefix, "prefix (StatsD) or measurement (InfluxDB) of pushed metrics")
+	flag.Var(&flags.PushTags, "push-tag", "tag of pushed metrics (e.g. 'env=staging')")
+	pushInterval := flag.String("push-interval", qrn.DefaultPushInterval.String(), "interval of pushed metrics")
+	traceEndpoint := flag.String("trace-endpoint", "", "OTLP/HTTP endpoint to export the spans of queries (e.g. 'http://localhost:4318/v1/traces')")
+	traceFile := flag.String("trace-file", "", "file path to write the spans of queries as OTLP JSON lines")
+	traceSample := flag.Float64("trace-sample", 1, "fraction of queries to trace")
+	traceComment := flag.Bool("trace-comment", false, "append the trace context to traced queries as a SQL comment (sqlcommenter)")
+	flag.BoolVar(&flags.TUI, "tui", false, "show the full-screen dashboard during the run instead of the status line (only on a terminal)")
+	flag.StringVar(&flags.Control, "control", "", "listen address of the control HTTP API (e.g. ':8080'). without a host, it listens on localhost only")
 	flag.BoolVar(&flags.Histogram, "histogram", false, "show histogram")
 	flag.BoolVar(&flags.HTML, "html", false, "output histogram html")
+	flag.StringVar(&flags.HTMLReport, "html-report", "", "file path of the self-contained HTML report with throughput, latency, errors and queries over time")
+	flag.StringVar(&flags.ReportFormat, "report-format", qrn.ReportFormatJSON, "format of the report (json/markdown/csv/junit)")
+	flag.StringVar(&flags.ReportOutput, "report-output", "", "file path to write the report to instead of stdout. csv rows are appended")
 	argVersion := flag.Bool("version", false, "Print version and exit")
 	flag.Parse()
 
@@ -85,15 +143,39 @@ func parseFlags() (flags *Flags) {
 		printVersionAndEixt()
 	}
 
-	if flags.TaskOptions.DSN == "" {
+	if len(flags.TaskOptions.DSNs) == 0 {
 		printErrorAndExit("'-dsn' is required")
 	}
 
-	if flags.TaskOptions.Driver == "" {
-		if strings.HasPrefix(flags.TaskOptions.DSN, "postgres:") {
-			flags.TaskOptions.Driver = "pgx"
-		} else {
-			flags.TaskOptions.Driver = "mysql"
+	switch flags.TaskOptions.Balance {
+	case qrn.BalanceRoundRobin:
+		// nothing to do
+	case qrn.BalanceLeastLatency:
+		// the initial agents are distributed by round-robin before any latency is measured
+		if flags.TaskOptions.RampStep <= 0 && flags.Control == "" {
+			printErrorAndExit("'-balance least-latency' requires '-ramp-step' > 0 or '-control' to add agents during the run")
+		}
+	case qrn.BalanceWeighted:
+		if len(flags.TaskOptions.Weights) != len(flags.TaskOptions.DSNs) {
+			printErrorAndExit("the number of '-weight' must be the same as '-dsn'")
+		}
+
+		for _, w := range flags.TaskOptions.Weights {
+			if w < 1 {
+				printErrorAndExit("'-weight' must be >= 1")
+			}
+		}
+	default:
+		printErrorAndExit("'-balance' must be 'round-robin', 'weighted' or 'least-latency'")
+	}
+
+	if flags.TaskOptions.Compare {
+		if len(flags.TaskOptions.DSNs) != 2 {
+			printErrorAndExit("'-compare' requires two '-dsn'")
+		}
+
+		if flags.TaskOptions.Balance != qrn.BalanceRoundRobin {
+			printErrorAndExit("'-balance' cannot be used with '-compare'")
 		}
 	}
 
@@ -151,6 +233,201 @@ func parseFlags() (flags *Flags) {
 		flags.TaskOptions.HInterval = hi
 	}
 
+	if ri, err := time.ParseDuration(*rampInterval); err != nil {
+		printErrorAndExit(err.Error())
+	} else {
+		flags.TaskOptions.RampInterval = ri
+	}
+
+	if flags.TaskOptions.RampStep != 0 {
+		if flags.TaskOptions.RampInterval <= 0 {
+			printErrorAndExit("'-ramp-interval' must be > 0")
+		}
+
+		if flags.TaskOptions.RampLimit < 1 {
+			printErrorAndExit("'-ramp-limit' must be >= 1")
+		}
+
+		if flags.TaskOptions.RampStep > 0 && flags.TaskOptions.RampLimit <= flags.TaskOptions.NAgents {
+			printErrorAndExit("'-ramp-limit' must be > '-nagents' when '-ramp-step' is positive")
+		} else if flags.TaskOptions.RampStep < 0 && flags.TaskOptions.RampLimit >= flags.TaskOptions.NAgents {
+			printErrorAndExit("'-ramp-limit' must be < '-nagents' when '-ramp-step' is negative")
+		}
+	}
+
+	if qt, err := time.ParseDuration(*queryTimeout); err != nil {
+		printErrorAndExit(err.Error())
+	} else if qt < 0 {
+		printErrorAndExit("'-query-timeout' must be >= 0")
+	} else {
+		flags.TaskOptions.QueryTimeout = qt
+	}
+
+	switch *timeoutLatency {
+	case "exclude":
+		flags.TaskOptions.ClampTimeouts = false
+	case "clamp":
+		flags.TaskOptions.ClampTimeouts = true
+	default:
+		printErrorAndExit("'-timeout-latency' must be 'exclude' or 'clamp'")
+	}
+
+	switch flags.TaskOptions.ConnMode {
+	case qrn.ConnModePersistent, qrn.ConnModeQuery, qrn.ConnModeTx:
+		// nothing to do
+	case qrn.ConnModeLifetime:
+		if *connLifetime == "" {
+			printErrorAndExit("'-conn-lifetime' is required for '-conn-mode lifetime'")
+		}
+
+		if lt, err := qrn.ParseDistribution(*connLifetime); err != nil {
+			printErrorAndExit(err.Error())
+		} else {
+			flags.TaskOptions.ConnLifetime = lt
+		}
+	default:
+		printErrorAndExit("'-conn-mode' must be 'persistent', 'query', 'tx' or 'lifetime'")
+	}
+
+	if ai, err := time.ParseDuration(*assertInterval); err != nil {
+		printErrorAndExit(err.Error())
+	} else {
+		flags.TaskOptions.AssertInterval = ai
+	}
+
+	if flags.TaskOptions.ShadowDSN != "" {
+		if flags.TaskOptions.Compare {
+			printErrorAndExit("'-shadow-dsn' cannot be used with '-compare'")
+		}
+
+		if *shadowLog == "" {
+			flags.TaskOptions.ShadowLogger = qrn.NewShadowLogger(os.Stderr)
+		} else {
+			file, err := os.OpenFile(*shadowLog, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+
+			if err != nil {
+				printErrorAndExit(err.Error())
+			}
+
+			flags.ShadowLog = file
+			flags.TaskOptions.ShadowLogger = qrn.NewShadowLogger(file)
+		}
+	}
+
+	switch flags.ReportFormat {
+	case qrn.ReportFormatJSON, qrn.ReportFormatMarkdown, qrn.ReportFormatCSV, qrn.ReportFormatJUnit:
+		// nothing to do
+	default:
+		printErrorAndExit("'-report-format' must be 'json', 'markdown', 'csv' or 'junit'")
+	}
+
+	if flags.Push != "" {
+		switch flags.PushFormat {
+		case qrn.PushFormatStatsD, qrn.PushFormatDogStatsD, qrn.PushFormatInflux:
+			// nothing to do
+		default:
+			printErrorAndExit("'-push-format' must be 'statsd', 'dogstatsd' or 'influx'")
+		}
+
+		if flags.PushFormat == qrn.PushFormatStatsD && len(flags.PushTags) > 0 {
+			printErrorAndExit("'-push-tag' requires '-push-format dogstatsd' or 'influx'")
+		}
+
+		if pi, err := time.ParseDuration(*pushInterval); err != nil {
+			printErrorAndExit(err.Error())
+		} else if pi <= 0 {
+			printErrorAndExit("'-push-interval' must be > 0")
+		} else {
+			flags.PushInterval = pi
+		}
+
+		for _, t := range flags.PushTags {
+			if !strings.Contains(t, "=") {
+				printErrorAndExit("'-push-tag' must be 'key=value'")
+			}
+		}
+	}
+
+	if *traceEndpoint != "" && *traceFile != "" {
+		printErrorAndExit("please specify one of '-trace-endpoint' or '-trace-file'")
+	} else if *traceEndpoint != "" || *traceFile != "" {
+		if *traceSample <= 0 || *traceSample > 1 {
+			printErrorAndExit("'-trace-sample' must be > 0 and <= 1")
+		}
+
+		if *traceFile != "" {
+			file, err := os.OpenFile(*traceFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+
+			if err != nil {
+				printErrorAndExit(err.Error())
+			}
+
+			flags.TraceFile = file
+			flags.TaskOptions.Tracer = qrn.NewTracer("", file, *traceSample, *traceComment)
+		} else {
+			flags.TaskOptions.Tracer = qrn.NewTracer(*traceEndpoint, nil, *traceSample, *traceComment)
+		}
+	}
+
+	if *timeSeries != "" {
+		if ti, err := time.ParseDuration(*timeSeriesInterval); err != nil {
+			printErrorAndExit(err.Error())
+		} else if ti <= 0 {
+			printErrorAndExit("'-timeseries-interval' must be > 0")
+		} else {
+			flags.TimeSeriesInterval = ti
+		}
+
+		format := qrn.TimeSeriesJSONL
+
+		if strings.HasSuffix(*timeSeries, ".csv") {
+			format = qrn.TimeSeriesCSV
+		}
+
+		file, err := os.OpenFile(*timeSeries, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+
+		if err != nil {
+			printErrorAndExit(err.Error())
+		}
+
+		flags.TimeSeries = file
+		flags.TaskOptions.TimeSeries = qrn.NewTimeSeries(file, format, flags.TimeSeriesInterval)
+	}
+
+	if *hlog != "" {
+		hi, err := time.ParseDuration(*hlogInterval)
+
+		if err != nil {
+			printErrorAndExit(err.Error())
+		} else if hi <= 0 {
+			printErrorAndExit("'-hlog-interval' must be > 0")
+		}
+
+		if *hlogDigits < 1 || *hlogDigits > 5 {
+			printErrorAndExit("'-hlog-digits' must be >= 1 and <= 5")
+		}
+
+		file, err := os.OpenFile(*hlog, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+
+		if err != nil {
+			printErrorAndExit(err.Error())
+		}
+
+		flags.HistogramLog = file
+		flags.TaskOptions.HistogramLog = qrn.NewHistogramLog(file, hi, *hlogDigits)
+	}
+
+	if *samples != "" {
+		file, err := os.OpenFile(*samples, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+
+		if err != nil {
+			printErrorAndExit(err.Error())
+		}
+
+		flags.Samples = file
+		flags.SampleWriter = qrn.NewSampleWriter(file)
+	}
+
 	if *logOpt == "" {
 		devNull := &qrn.ClosableDiscard{}
 		logger := qrn.NewLogger(devNull, 0)
@@ -176,6 +453,9 @@ func parseFlags() (flags *Flags) {
 func printUsageAndExit() {
 	fmt.Fprintf(os.Stderr, "Usage of %s:\n", os.Args[0])
 	flag.PrintDefaults()
+	fmt.Fprintf(os.Stderr, "\nSubcommands:\n")
+	fmt.Fprintf(os.Stderr, "  analyze\n    \trecompute the report from the dump of '-samples' (see '%s analyze -h')\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "  compare\n    \tcompare two JSON reports and exit with %d on regression (see '%s compare -h')\n", ExitRegression, os.Args[0])
 	os.Exit(2)
 }
 
@@ -1,12 +1,13 @@
 package main
 
 import (
-	"encoding/json"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
 	"qrn"
 	"strings"
+	"syscall"
 	"time"
 
 	"golang.org/x/term"
@@ -14,23 +15,49 @@ import (
 
 const ReportPeriod = 1
 const HTMLReportName = "qrn-%d.html"
+const DefaultTermWidth = 80
+
+// ExitAssertionFailed is the exit code when an assertion fails.
+// An execution failure exits with 1.
+const ExitAssertionFailed = 3
+
+// ExitInterrupted is the exit code when the run is interrupted by a signal.
+const ExitInterrupted = 130
 
 func init() {
 	log.SetFlags(log.LstdFlags)
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "analyze":
+			os.Exit(analyzeMain(os.Args[2:]))
+		case "compare":
+			os.Exit(compareMain(os.Args[2:]))
+		}
+	}
+
+	os.Exit(run())
+}
+
+// run runs the test and returns the exit code.
+// Errors are returned as the exit code instead of exiting so that the deferred cleanups are run.
+func run() int {
 	flags := parseFlags()
+	queryFile := ""
 
 	if flags.Query != "" {
 		path, err := queryToFile(flags.Query)
 
 		if path != "" {
+			queryFile = path
 			defer os.Remove(path)
 		}
 
 		if err != nil {
-			log.Fatalf("query error: %s", err)
+			log.Printf("query error: %s", err)
+			return 1
 		}
 
 		flags.TaskOptions.Files = qrn.Strings{path}
@@ -38,31 +65,201 @@ func main() {
 
 	task := qrn.NewTask(flags.TaskOptions)
 
+	if flags.SampleWriter != nil {
+		task.AddObserver(flags.SampleWriter)
+	}
+
+	var dashboard *Dashboard
+
+	if flags.TUI && term.IsTerminal(int(os.Stdout.Fd())) {
+		dashboard = NewDashboard(task, os.Stdout, flags.Time)
+	}
+
+	handleSignals(task, dashboard, queryFile)
+
 	err := task.Prepare()
 
 	if err != nil {
-		log.Fatalf("task prepare error: %s", err)
+		log.Printf("task prepare error: %s", err)
+		return 1
+	}
+
+	if flags.Control != "" {
+		cs := qrn.NewControlServer(task)
+		err := cs.Start(flags.Control)
+
+		if err != nil {
+			log.Printf("control server error: %s", err)
+			return 1
+		}
+
+		defer cs.Close()
 	}
 
-	recorder, err := task.Run(flags.Time, ReportPeriod*time.Second, withProgress(func(count int, qps float64, width int, elapsed time.Duration, running int) {
-		d := elapsed.Round(time.Second)
-		m := d / time.Minute
-		s := (d - m*time.Minute) / time.Second
-		status := fmt.Sprintf("%02d:%02d | %d agents / run %d queries (%.0f qps)", m, s, running, count, qps)
+	if flags.MetricsListen != "" {
+		ms := qrn.NewMetricsServer(task)
+		err := ms.Start(flags.MetricsListen)
+
+		if err != nil {
+			log.Printf("metrics server error: %s", err)
+			return 1
+		}
+
+		defer ms.Close()
+	}
+
+	var pusher *qrn.Pusher
+
+	if flags.Push != "" {
+		pusher = qrn.NewPusher(task, flags.Push, flags.PushFormat, flags.PushPrefix, flags.PushTags, flags.PushInterval)
+		err := pusher.Start()
+
+		if err != nil {
+			log.Printf("push error: %s", err)
+			return 1
+		}
+	}
+
+	progress := withProgress(func(count int, qps float64, width int, elapsed time.Duration, running int) {
+		status := fmt.Sprintf("%s | %d agents / run %d queries (%.0f qps)", formatElapsed(elapsed), running, count, qps)
 		fmt.Fprintf(os.Stderr, "\r%-*s", width, status)
-	}))
+	})
+
+	if dashboard != nil {
+		dashboard.Start()
+		progress = dashboard.Update
+	}
+
+	recorder, err := task.Run(flags.Time, ReportPeriod*time.Second, progress)
 
-	fmt.Fprintf(os.Stderr, "\r\n\n")
+	if dashboard != nil {
+		dashboard.Close()
+	} else {
+		fmt.Fprintf(os.Stderr, "\r\n\n")
+	}
+
+	if flags.TaskOptions.ShadowLogger != nil {
+		flags.TaskOptions.ShadowLogger.Close()
+
+		if dropped := flags.TaskOptions.ShadowLogger.Dropped; dropped > 0 {
+			fmt.Fprintf(os.Stderr, "%d mismatch logs were dropped\n", dropped)
+		}
+
+		if flags.ShadowLog != nil {
+			flags.ShadowLog.Close()
+		}
+	}
+
+	if flags.TimeSeries != nil {
+		flags.TimeSeries.Close()
+	}
+
+	if flags.HistogramLog != nil {
+		err := flags.TaskOptions.HistogramLog.Close()
+
+		if err == nil {
+			err = flags.HistogramLog.Close()
+		}
+
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "hlog error: %s\n", err)
+		}
+	}
+
+	if pusher != nil {
+		pusher.Close()
+	}
+
+	if tracer := flags.TaskOptions.Tracer; tracer != nil {
+		if err := tracer.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "trace export error: %s\n", err)
+		}
+
+		if tracer.Dropped > 0 {
+			fmt.Fprintf(os.Stderr, "%d spans were dropped\n", tracer.Dropped)
+		}
+
+		if flags.TraceFile != nil {
+			flags.TraceFile.Close()
+		}
+	}
+
+	// the samples are written even if the run fails, so that the failure can be analyzed
+	var sampleErr error
+
+	if flags.SampleWriter != nil {
+		sampleErr = flags.SampleWriter.Close(recorder)
+
+		if sampleErr == nil {
+			sampleErr = flags.Samples.Close()
+		} else {
+			flags.Samples.Close()
+		}
+
+		if sampleErr != nil {
+			log.Printf("sample dump error: %s", sampleErr)
+		}
+	}
 
 	if err != nil {
-		log.Fatalf("task run error: %s", err)
+		log.Printf("task run error: %s", err)
+		return 1
+	}
+
+	if sampleErr != nil {
+		return 1
 	}
 
-	err = showResult(flags, recorder)
+	report := recorder.Report()
+	err = showResult(flags, recorder, report)
 
 	if err != nil {
-		log.Fatalf("show result error: %s", err)
+		log.Printf("show result error: %s", err)
+		return 1
+	}
+
+	if report.Interrupted {
+		return ExitInterrupted
+	} else if report.AssertionFailed() {
+		return ExitAssertionFailed
 	}
+
+	return 0
+}
+
+// handleSignals stops the task gracefully at the first SIGINT/SIGTERM so that the report is still output.
+// The second signal forces exit.
+// The screen of the dashboard is restored and the temporary file of '-query' is removed before exit.
+func handleSignals(task *qrn.Task, dashboard *Dashboard, queryFile string) {
+	sigs := make(chan os.Signal, 2)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+
+	exit := func() {
+		if dashboard != nil {
+			dashboard.Close()
+		}
+
+		if queryFile != "" {
+			os.Remove(queryFile)
+		}
+
+		os.Exit(ExitInterrupted)
+	}
+
+	go func() {
+		<-sigs
+
+		if !task.Interrupt() {
+			exit()
+		}
+
+		if dashboard == nil {
+			fmt.Fprintf(os.Stderr, "\rinterrupted: waiting for running queries (press Ctrl-C again to force exit)\n")
+		}
+
+		<-sigs
+		exit()
+	}()
 }
 
 func withProgress(block func(int, float64, int, time.Duration, int)) func(*qrn.Recorder, int) {
@@ -79,25 +276,33 @@ func withProgress(block func(int, float64, int, time.Duration, int)) func(*qrn.R
 	}
 }
 
-func showResult(flags *Flags, recorder *qrn.Recorder) error {
-	report := recorder.Report()
-	rawJSON, _ := json.MarshalIndent(report, "", "  ")
-
+func showResult(flags *Flags, recorder *qrn.Recorder, report *qrn.RecordReport) error {
 	w, _, err := term.GetSize(0)
 
 	if err != nil {
-		return err
+		// not a terminal (e.g. CI or an interrupted pipeline)
+		w = DefaultTermWidth
 	}
 
-	if flags.Histogram {
+	if flags.Histogram && report.Response != nil && report.Response.Histogram != nil {
 		fmt.Fprintf(os.Stderr, "%s\n", report.Response.Histogram.String(w/3))
 	}
 
-	fmt.Println(string(rawJSON))
+	err = writeReport(flags, report)
+
+	if err != nil {
+		return err
+	}
 
 	if flags.HTML {
 		fname := fmt.Sprintf(HTMLReportName, time.Now().Unix())
-		err := recorder.WriteHTMLFile(fname, strings.Join(os.Args, " "))
+		title := strings.Join(os.Args, " ")
+
+		if report.Interrupted {
+			title += " (interrupted)"
+		}
+
+		err := recorder.WriteHTMLFile(fname, title)
 
 		if err != nil {
 			return err
@@ -106,5 +311,72 @@ func showResult(flags *Flags, recorder *qrn.Recorder) error {
 		fmt.Fprintf(os.Stderr, "\noutput %s\n", fname)
 	}
 
+	if flags.HTMLReport != "" {
+		title := fmt.Sprintf("qrn %s", report.Started.Format(time.RFC3339))
+
+		if report.Interrupted {
+			title += " (interrupted)"
+		}
+
+		err := recorder.WriteDashboardFile(flags.HTMLReport, report, title, commandLine())
+
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(os.Stderr, "output %s\n", flags.HTMLReport)
+	}
+
 	return nil
 }
+
+func writeReport(flags *Flags, report *qrn.RecordReport) error {
+	if flags.ReportOutput == "" {
+		return report.WriteReport(os.Stdout, flags.ReportFormat, true)
+	}
+
+	mode := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+
+	if flags.ReportFormat == qrn.ReportFormatCSV {
+		// append a row for each run
+		mode = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	}
+
+	file, err := os.OpenFile(flags.ReportOutput, mode, 0644)
+
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+	info, err := file.Stat()
+
+	if err != nil {
+		return err
+	}
+
+	err = report.WriteReport(file, flags.ReportFormat, info.Size() == 0)
+
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "output %s\n", flags.ReportOutput)
+
+	return file.Close()
+}
+
+// commandLine returns the arguments quoted for the shell.
+func commandLine() string {
+	args := make([]string, len(os.Args))
+
+	for i, arg := range os.Args {
+		if arg == "" || strings.ContainsAny(arg, " \t\n'\"\\$`!*?&|;<>()[]{}#~") {
+			arg = "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+		}
+
+		args[i] = arg
+	}
+
+	return strings.Join(args, " ")
+}
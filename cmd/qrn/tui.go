@@ -0,0 +1,427 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"qrn"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// SlowQueryWindow is the number of report periods in which the slowest queries are shown.
+const SlowQueryWindow = 10
+
+// MaxSlowQueries is the number of the slowest recent queries shown.
+const MaxSlowQueries = 10
+
+const (
+	ansiReset  = "\x1b[0m"
+	ansiBold   = "\x1b[1m"
+	ansiDim    = "\x1b[2m"
+	ansiRed    = "\x1b[31m"
+	ansiGreen  = "\x1b[32m"
+	ansiYellow = "\x1b[33m"
+)
+
+var sparks = []rune("▁▂▃▄▅▆▇█")
+
+// Dashboard is the full-screen terminal dashboard of a running task, refreshed at each report period.
+type Dashboard struct {
+	sync.Mutex
+	Task       *qrn.Task
+	Out        *os.File
+	Duration   time.Duration
+	latencies  []time.Duration
+	errors     map[string]int
+	slowest    [][]qrn.DataPoint
+	qps        []float64
+	p50        []float64
+	p99        []float64
+	prevCount  int
+	prevErrors map[int]int64
+	started    time.Time
+	closeOnce  sync.Once
+	active     bool
+}
+
+func NewDashboard(task *qrn.Task, out *os.File, duration time.Duration) *Dashboard {
+	dashboard := &Dashboard{
+		Task:       task,
+		Out:        out,
+		Duration:   duration,
+		errors:     map[string]int{},
+		prevErrors: map[int]int64{},
+	}
+
+	task.AddObserver(dashboard)
+
+	return dashboard
+}
+
+// Start switches to the alternate screen.
+func (dashboard *Dashboard) Start() {
+	dashboard.Lock()
+	defer dashboard.Unlock()
+	dashboard.started = time.Now()
+	dashboard.active = true
+	fmt.Fprint(dashboard.Out, "\x1b[?1049h\x1b[?25l\x1b[H\x1b[2J")
+}
+
+// Close restores the screen. It can be called more than once.
+func (dashboard *Dashboard) Close() {
+	dashboard.closeOnce.Do(func() {
+		dashboard.Lock()
+		defer dashboard.Unlock()
+
+		if dashboard.active {
+			fmt.Fprint(dashboard.Out, "\x1b[?25h\x1b[?1049l")
+			dashboard.active = false
+		}
+	})
+}
+
+func (dashboard *Dashboard) Observe(responseTimes []qrn.DataPoint) {
+	dashboard.Lock()
+	defer dashboard.Unlock()
+
+	if len(dashboard.slowest) == 0 {
+		dashboard.slowest = append(dashboard.slowest, nil)
+	}
+
+	current := dashboard.slowest[len(dashboard.slowest)-1]
+
+	for _, v := range responseTimes {
+		if v.Route == qrn.RouteShadow {
+			continue
+		}
+
+		if v.Error != "" {
+			dashboard.errors[v.Error]++
+		} else {
+			dashboard.latencies = append(dashboard.latencies, v.ResponseTime)
+		}
+
+		current = append(current, v)
+	}
+
+	// keep only the slowest points of the current period
+	sort.Slice(current, func(i, j int) bool { return current[i].ResponseTime > current[j].ResponseTime })
+
+	if len(current) > MaxSlowQueries {
+		current = current[:MaxSlowQueries]
+	}
+
+	dashboard.slowest[len(dashboard.slowest)-1] = current
+}
+
+// Update aggregates the last period and redraws the screen.
+func (dashboard *Dashboard) Update(recorder *qrn.Recorder, running int) {
+	statuses := dashboard.Task.AgentStatuses()
+	pool := dashboard.Task.PoolStats()
+	count := recorder.Count()
+
+	dashboard.Lock()
+	defer dashboard.Unlock()
+
+	if !dashboard.active {
+		return
+	}
+
+	latencies := dashboard.latencies
+	dashboard.latencies = nil
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+
+	percentile := func(p float64) float64 {
+		if len(latencies) == 0 {
+			return 0
+		}
+
+		return float64(latencies[int(float64(len(latencies)-1)*p)]) / float64(time.Millisecond)
+	}
+
+	dashboard.qps = append(dashboard.qps, float64(count-dashboard.prevCount)/ReportPeriod)
+	dashboard.p50 = append(dashboard.p50, percentile(0.5))
+	dashboard.p99 = append(dashboard.p99, percentile(0.99))
+	dashboard.prevCount = count
+
+	width, height, err := term.GetSize(int(dashboard.Out.Fd()))
+
+	if err != nil {
+		width, height = DefaultTermWidth, 24
+	}
+
+	lines := dashboard.render(width, height, running, statuses, pool)
+
+	dashboard.slowest = append(dashboard.slowest, nil)
+
+	if len(dashboard.slowest) > SlowQueryWindow {
+		dashboard.slowest = dashboard.slowest[1:]
+	}
+
+	// keep the history within the screen width
+	if n := len(dashboard.qps) - width; n > 0 {
+		dashboard.qps = dashboard.qps[n:]
+		dashboard.p50 = dashboard.p50[n:]
+		dashboard.p99 = dashboard.p99[n:]
+	}
+
+	var screen strings.Builder
+	screen.WriteString("\x1b[H")
+
+	for i, line := range lines {
+		if i >= height {
+			break
+		}
+
+		if i > 0 {
+			screen.WriteString("\r\n")
+		}
+
+		screen.WriteString(line)
+		screen.WriteString(ansiReset + "\x1b[K")
+	}
+
+	screen.WriteString("\x1b[J")
+	fmt.Fprint(dashboard.Out, screen.String())
+}
+
+func sparkline(values []float64, width int) string {
+	if width < 1 {
+		return ""
+	} else if len(values) > width {
+		values = values[len(values)-width:]
+	}
+
+	max := 0.0
+
+	for _, v := range values {
+		if v > max {
+			max = v
+		}
+	}
+
+	line := make([]rune, len(values))
+
+	for i, v := range values {
+		n := 0
+
+		if max > 0 {
+			n = int(v / max * float64(len(sparks)-1))
+		}
+
+		line[i] = sparks[n]
+	}
+
+	return string(line)
+}
+
+func formatElapsed(d time.Duration) string {
+	d = d.Round(time.Second)
+	m := d / time.Minute
+	s := (d - m*time.Minute) / time.Second
+	return fmt.Sprintf("%02d:%02d", m, s)
+}
+
+func truncate(s string, width int) string {
+	r := []rune(s)
+
+	if width <= 0 {
+		return ""
+	} else if len(r) > width {
+		return string(r[:width-1]) + "…"
+	}
+
+	return s
+}
+
+func compactCount(n int64) string {
+	switch {
+	case n >= 1000000:
+		return fmt.Sprintf("%.1fM", float64(n)/1000000)
+	case n >= 1000:
+		return fmt.Sprintf("%.1fk", float64(n)/1000)
+	default:
+		return fmt.Sprintf("%d", n)
+	}
+}
+
+func (dashboard *Dashboard) render(width int, height int, running int, statuses []qrn.AgentStatus, pool sql.DBStats) []string {
+	lines := []string{}
+	add := func(format string, a ...interface{}) {
+		lines = append(lines, fmt.Sprintf(format, a...))
+	}
+
+	task := dashboard.Task
+	elapsed := formatElapsed(time.Since(dashboard.started))
+
+	if dashboard.Duration > 0 {
+		elapsed += " / " + formatElapsed(dashboard.Duration)
+	}
+
+	rate := "unlimited"
+
+	if r := task.Rate(); r > 0 {
+		rate = fmt.Sprintf("%d qps/agent", r)
+	}
+
+	header := fmt.Sprintf("%sqrn%s  %s | %d agents | rate %s", ansiBold, ansiReset, elapsed, running, rate)
+
+	if task.Paused() {
+		header += " | " + ansiYellow + "paused" + ansiReset
+	}
+
+	add("%s", header)
+	add("")
+
+	sparkWidth := width - 20
+	last := func(values []float64) float64 { return values[len(values)-1] }
+	add("qps  %11.0f  %s%s", last(dashboard.qps), ansiGreen, sparkline(dashboard.qps, sparkWidth))
+	add("p50  %9.2fms  %s", last(dashboard.p50), sparkline(dashboard.p50, sparkWidth))
+	add("p99  %9.2fms  %s%s", last(dashboard.p99), ansiYellow, sparkline(dashboard.p99, sparkWidth))
+	add("")
+
+	var nRunning, nFinished, nErroring, nFailed int
+	var errors, newErrors int64
+
+	for _, v := range statuses {
+		errors += v.Errors
+		newErrors += v.Errors - dashboard.prevErrors[v.Id]
+
+		switch v.State {
+		case "running":
+			nRunning++
+
+			if v.Errors > dashboard.prevErrors[v.Id] {
+				nErroring++
+			}
+		case "finished":
+			nFinished++
+		case "failed":
+			nFailed++
+		}
+	}
+
+	errorLine := fmt.Sprintf("errors %d (+%d)", errors, newErrors)
+	classes := make([]string, 0, len(dashboard.errors))
+
+	for class := range dashboard.errors {
+		classes = append(classes, class)
+	}
+
+	sort.Strings(classes)
+
+	for i, class := range classes {
+		if i == 0 {
+			errorLine += " |"
+		}
+
+		errorLine += fmt.Sprintf(" %s: %d", class, dashboard.errors[class])
+	}
+
+	// truncate before colouring so that the escape sequences are not cut
+	errorLine = truncate(errorLine, width)
+
+	if newErrors > 0 {
+		errorLine = ansiRed + errorLine + ansiReset
+	}
+
+	add("%s", errorLine)
+	add("conns  open %d  in use %d  idle %d | waits %d (%s) | closed %d",
+		pool.OpenConnections, pool.InUse, pool.Idle, pool.WaitCount, pool.WaitDuration.Round(time.Millisecond),
+		pool.MaxIdleClosed+pool.MaxIdleTimeClosed+pool.MaxLifetimeClosed)
+	add("")
+
+	add("%sagents%s  running %d  erroring %d  finished %d  failed %d", ansiBold, ansiReset, nRunning, nErroring, nFinished, nFailed)
+
+	const cellWidth = 22
+	columns := width / cellWidth
+
+	if columns < 1 {
+		columns = 1
+	}
+
+	// leave room for the slowest queries
+	rows := height - len(lines) - MaxSlowQueries - 3
+
+	if rows < 1 {
+		rows = 1
+	}
+
+	shown := statuses
+
+	if len(shown) > rows*columns {
+		shown = shown[:rows*columns-1]
+	}
+
+	var row strings.Builder
+
+	for i, v := range shown {
+		color, state := ansiGreen, v.State
+
+		switch v.State {
+		case "running":
+			if v.Errors > dashboard.prevErrors[v.Id] {
+				color, state = ansiRed, "erroring"
+			}
+		case "failed":
+			color = ansiRed + ansiBold
+		default:
+			color = ansiDim
+		}
+
+		fmt.Fprintf(&row, "%s%4d %-8s %7s%s ", color, v.Id, state, compactCount(v.Queries), ansiReset)
+
+		if (i+1)%columns == 0 {
+			add("%s", row.String())
+			row.Reset()
+		}
+	}
+
+	if len(shown) < len(statuses) {
+		fmt.Fprintf(&row, "%s... %d more%s", ansiDim, len(statuses)-len(shown), ansiReset)
+	}
+
+	if row.Len() > 0 {
+		add("%s", row.String())
+	}
+
+	for _, v := range statuses {
+		dashboard.prevErrors[v.Id] = v.Errors
+	}
+
+	slowest := []qrn.DataPoint{}
+
+	for _, points := range dashboard.slowest {
+		slowest = append(slowest, points...)
+	}
+
+	sort.Slice(slowest, func(i, j int) bool { return slowest[i].ResponseTime > slowest[j].ResponseTime })
+
+	if len(slowest) > MaxSlowQueries {
+		slowest = slowest[:MaxSlowQueries]
+	}
+
+	add("")
+	add("%sslowest queries%s (last %ds)", ansiBold, ansiReset, SlowQueryWindow*ReportPeriod)
+
+	for _, v := range slowest {
+		rt := fmt.Sprintf("%10.2fms", float64(v.ResponseTime)/float64(time.Millisecond))
+		query := strings.Join(strings.Fields(v.Fingerprint), " ")
+
+		if v.Error != "" {
+			tag := "[" + v.Error + "]"
+			query = ansiRed + truncate(tag, width-14) + ansiReset + " " + truncate(query, width-14-len([]rune(tag))-1)
+		} else {
+			query = truncate(query, width-14)
+		}
+
+		add("%s  %s", rt, query)
+	}
+
+	return lines
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSparkline(t *testing.T) {
+	tests := []struct {
+		values   []float64
+		width    int
+		expected string
+	}{
+		{[]float64{0, 1, 2, 3, 4, 5, 6, 7}, 8, "▁▂▃▄▅▆▇█"},
+		{[]float64{0, 7, 14}, 8, "▁▄█"},
+		// the latest values that fit
+		{[]float64{7, 0, 7}, 2, "▁█"},
+		{[]float64{0, 0}, 8, "▁▁"},
+		{[]float64{}, 8, ""},
+		{[]float64{1}, 0, ""},
+	}
+
+	for _, tt := range tests {
+		if line := sparkline(tt.values, tt.width); line != tt.expected {
+			t.Errorf("sparkline(%v, %d): expected %q, got %q", tt.values, tt.width, tt.expected, line)
+		}
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		s        string
+		width    int
+		expected string
+	}{
+		{"select 1", 10, "select 1"},
+		{"select 1", 8, "select 1"},
+		{"select 1", 7, "select…"},
+		{"選択する", 3, "選択…"},
+		{"select 1", 0, ""},
+	}
+
+	for _, tt := range tests {
+		if s := truncate(tt.s, tt.width); s != tt.expected {
+			t.Errorf("truncate(%q, %d): expected %q, got %q", tt.s, tt.width, tt.expected, s)
+		}
+	}
+}
+
+func TestCompactCount(t *testing.T) {
+	tests := []struct {
+		n        int64
+		expected string
+	}{
+		{0, "0"},
+		{999, "999"},
+		{1000, "1.0k"},
+		{12345, "12.3k"},
+		{1000000, "1.0M"},
+		{2500000, "2.5M"},
+	}
+
+	for _, tt := range tests {
+		if s := compactCount(tt.n); s != tt.expected {
+			t.Errorf("compactCount(%d): expected %q, got %q", tt.n, tt.expected, s)
+		}
+	}
+}
+
+func TestFormatElapsed(t *testing.T) {
+	if s := formatElapsed(61*time.Second + 600*time.Millisecond); s != "01:02" {
+		t.Errorf("expected 01:02, got %s", s)
+	}
+}
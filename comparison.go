@@ -0,0 +1,140 @@
+package qrn
+
+import (
+	"sort"
+	"time"
+
+	"github.com/winebarrel/tachymeter"
+)
+
+// ComparedLatencies are the latency metrics in the differential report.
+var ComparedLatencies = []string{"p50", "p95", "p99", "avg", "max"}
+
+// ComparisonReport is the differential report of an A/B run. B is compared against A.
+type ComparisonReport struct {
+	A            *TargetReport
+	B            *TargetReport
+	QPS          *Delta
+	ErrorRate    *Delta
+	Latency      map[string]*LatencyDelta
+	Fingerprints []*FingerprintDelta
+}
+
+type Delta struct {
+	A      float64
+	B      float64
+	Diff   float64
+	Change float64
+}
+
+type LatencyDelta struct {
+	A      time.Duration
+	B      time.Duration
+	Diff   time.Duration
+	Change float64
+}
+
+type FingerprintDelta struct {
+	Fingerprint string
+	Queries     [2]int
+	Errors      [2]int
+	Latency     map[string]*LatencyDelta
+}
+
+func newDelta(a float64, b float64) *Delta {
+	d := &Delta{A: a, B: b, Diff: b - a}
+
+	if a != 0 {
+		d.Change = d.Diff / a
+	}
+
+	return d
+}
+
+func newLatencyDelta(a time.Duration, b time.Duration) *LatencyDelta {
+	d := &LatencyDelta{A: a, B: b, Diff: b - a}
+
+	if a != 0 {
+		d.Change = float64(d.Diff) / float64(a)
+	}
+
+	return d
+}
+
+func compareLatencies(a *tachymeter.Metrics, b *tachymeter.Metrics) map[string]*LatencyDelta {
+	deltas := map[string]*LatencyDelta{}
+	ra := &RecordReport{Response: a}
+	rb := &RecordReport{Response: b}
+
+	for _, name := range ComparedLatencies {
+		metric := latencyMetrics[name]
+		deltas[name] = newLatencyDelta(metric(ra), metric(rb))
+	}
+
+	return deltas
+}
+
+func (recorder *Recorder) comparisonReport(targets []*TargetReport) *ComparisonReport {
+	a, b := targets[0], targets[1]
+
+	report := &ComparisonReport{
+		A:         a,
+		B:         b,
+		QPS:       newDelta(a.QPS, b.QPS),
+		ErrorRate: newDelta(a.ErrorRate, b.ErrorRate),
+		Latency:   compareLatencies(a.Response, b.Response),
+	}
+
+	responseTimes := map[string]*[2][]DataPoint{}
+	deltas := map[string]*FingerprintDelta{}
+
+	fingerprint := func(v DataPoint) *FingerprintDelta {
+		d, ok := deltas[v.Fingerprint]
+
+		if !ok {
+			d = &FingerprintDelta{Fingerprint: v.Fingerprint}
+			deltas[v.Fingerprint] = d
+			responseTimes[v.Fingerprint] = &[2][]DataPoint{}
+		}
+
+		return d
+	}
+
+	for _, v := range recorder.ResponseTimes {
+		if v.Target > 1 {
+			continue
+		}
+
+		fingerprint(v).Queries[v.Target]++
+		rts := responseTimes[v.Fingerprint]
+		rts[v.Target] = append(rts[v.Target], v)
+	}
+
+	for _, v := range recorder.ErrorPoints {
+		if v.Target > 1 {
+			continue
+		}
+
+		fingerprint(v).Errors[v.Target]++
+	}
+
+	for fp, d := range deltas {
+		rts := responseTimes[fp]
+		d.Latency = compareLatencies(recorder.calcMetrics(rts[0]), recorder.calcMetrics(rts[1]))
+		report.Fingerprints = append(report.Fingerprints, d)
+	}
+
+	// the most frequent queries first
+	sort.Slice(report.Fingerprints, func(i, j int) bool {
+		fi, fj := report.Fingerprints[i], report.Fingerprints[j]
+		ni, nj := fi.Queries[0]+fi.Queries[1], fj.Queries[0]+fj.Queries[1]
+
+		if ni != nj {
+			return ni > nj
+		}
+
+		return fi.Fingerprint < fj.Fingerprint
+	})
+
+	return report
+}
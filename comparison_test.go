@@ -0,0 +1,68 @@
+package qrn
+
+import (
+	"testing"
+	"time"
+)
+
+func TestComparisonReport(t *testing.T) {
+	options := &TaskOptions{
+		DSNs:    Strings{"fake:latency=1ms", "fake:latency=5ms"},
+		Compare: true,
+		Files:   Strings{testData(t, "select 1", "select name from users where id = 1")},
+		Loop:    true,
+		NAgents: 2,
+	}
+
+	_, report, err := runTask(t, options, 300*time.Millisecond, 10*time.Millisecond)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := report.Comparison
+
+	if c == nil {
+		t.Fatal("expected a comparison report")
+	}
+
+	if c.A.NAgents != 2 || c.B.NAgents != 2 {
+		t.Errorf("expected 2 agents for each target, got %d and %d", c.A.NAgents, c.B.NAgents)
+	}
+
+	// B is slower than A
+	if p50 := c.Latency["p50"]; p50.B <= p50.A || p50.Diff != p50.B-p50.A || p50.Change <= 1 {
+		t.Errorf("unexpected p50 delta: %+v", p50)
+	}
+
+	if c.QPS.B >= c.QPS.A || c.QPS.Change >= 0 {
+		t.Errorf("unexpected QPS delta: %+v", c.QPS)
+	}
+
+	if len(c.Fingerprints) != 2 {
+		t.Fatalf("expected 2 fingerprints, got %d", len(c.Fingerprints))
+	}
+
+	for _, v := range c.Fingerprints {
+		if v.Queries[0] == 0 || v.Queries[1] == 0 {
+			t.Errorf("expected queries on both targets for %q, got %v", v.Fingerprint, v.Queries)
+		}
+
+		if v.Latency["p50"].B <= v.Latency["p50"].A {
+			t.Errorf("expected %q to be slower on B: %+v", v.Fingerprint, v.Latency["p50"])
+		}
+	}
+}
+
+func TestNewDelta(t *testing.T) {
+	d := newDelta(200, 150)
+
+	if d.Diff != -50 || d.Change != -0.25 {
+		t.Errorf("unexpected delta: %+v", d)
+	}
+
+	// the change from zero is undefined
+	if d := newDelta(0, 1); d.Change != 0 {
+		t.Errorf("expected no change from zero, got %g", d.Change)
+	}
+}
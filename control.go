@@ -0,0 +1,206 @@
+package qrn
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+type ControlStatus struct {
+	Started time.Time
+	Elapsed time.Duration
+	Queries int
+	QPS     float64
+	NAgents int
+	Rate    int
+	Paused  bool
+}
+
+// ControlServer is an HTTP API to inspect and steer a running task.
+//
+//	GET  /status               current status
+//	POST /rate?value=N         change the rate limit for each agent
+//	POST /agents?value=N       change the number of agents
+//	POST /pause                pause all agents
+//	POST /resume               resume all agents
+//	POST /stop                 stop the run gracefully and report
+//	POST /annotate?text=TEXT   add an annotation to the report
+//
+// The API has no authentication, so it listens on the loopback interface unless a host is given.
+type ControlServer struct {
+	Task   *Task
+	server *http.Server
+}
+
+func NewControlServer(task *Task) *ControlServer {
+	cs := &ControlServer{Task: task}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", cs.handleStatus)
+	mux.HandleFunc("/rate", cs.post(cs.handleRate))
+	mux.HandleFunc("/agents", cs.post(cs.handleAgents))
+	mux.HandleFunc("/pause", cs.post(cs.handlePause))
+	mux.HandleFunc("/resume", cs.post(cs.handleResume))
+	mux.HandleFunc("/stop", cs.post(cs.handleStop))
+	mux.HandleFunc("/annotate", cs.post(cs.handleAnnotate))
+	cs.server = &http.Server{Handler: mux}
+
+	return cs
+}
+
+func (cs *ControlServer) Start(addr string) error {
+	listener, err := net.Listen("tcp", loopbackAddr(addr))
+
+	if err != nil {
+		return err
+	}
+
+	go cs.server.Serve(listener)
+
+	return nil
+}
+
+// loopbackAddr returns the address on the loopback interface if the host of addr is empty (e.g. ":8080").
+func loopbackAddr(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+
+	if err != nil || host != "" {
+		return addr
+	}
+
+	return net.JoinHostPort("127.0.0.1", port)
+}
+
+func (cs *ControlServer) Close() error {
+	return cs.server.Close()
+}
+
+func (cs *ControlServer) Status() *ControlStatus {
+	task := cs.Task
+
+	status := &ControlStatus{
+		NAgents: task.Running(),
+		Rate:    task.Rate(),
+		Paused:  task.Paused(),
+	}
+
+	if recorder := task.Recorder(); recorder != nil {
+		status.Started = recorder.Started
+		status.Elapsed = time.Since(recorder.Started)
+		status.Queries = recorder.Count()
+		status.QPS = float64(status.Queries) * float64(time.Second) / float64(status.Elapsed)
+	}
+
+	return status
+}
+
+func (cs *ControlServer) post(handler func(*http.Request) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if err := handler(r); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		cs.handleStatus(w, r)
+	}
+}
+
+func (cs *ControlServer) annotate(format string, a ...interface{}) {
+	if recorder := cs.Task.Recorder(); recorder != nil {
+		recorder.Annotate(fmt.Sprintf(format, a...))
+	}
+}
+
+func (cs *ControlServer) handleStatus(w http.ResponseWriter, r *http.Request) {
+	rawJSON, _ := jsoniter.Marshal(cs.Status())
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(rawJSON)
+	w.Write([]byte("\n"))
+}
+
+func intValue(r *http.Request) (int, error) {
+	v, err := strconv.Atoi(r.FormValue("value"))
+
+	if err != nil {
+		return 0, fmt.Errorf("invalid value: %w", err)
+	}
+
+	if v < 0 {
+		return 0, fmt.Errorf("value must be >= 0")
+	}
+
+	return v, nil
+}
+
+func (cs *ControlServer) handleRate(r *http.Request) error {
+	rate, err := intValue(r)
+
+	if err != nil {
+		return err
+	}
+
+	cs.Task.SetRate(rate)
+	cs.annotate("rate changed to %d", rate)
+
+	return nil
+}
+
+func (cs *ControlServer) handleAgents(r *http.Request) error {
+	n, err := intValue(r)
+
+	if err != nil {
+		return err
+	}
+
+	if n < 1 {
+		return fmt.Errorf("value must be >= 1")
+	}
+
+	err = cs.Task.Scale(n)
+
+	if err != nil {
+		return err
+	}
+
+	cs.annotate("agents changed to %d", n)
+
+	return nil
+}
+
+func (cs *ControlServer) handlePause(r *http.Request) error {
+	cs.Task.Pause()
+	cs.annotate("paused")
+	return nil
+}
+
+func (cs *ControlServer) handleResume(r *http.Request) error {
+	cs.Task.Resume()
+	cs.annotate("resumed")
+	return nil
+}
+
+func (cs *ControlServer) handleStop(r *http.Request) error {
+	cs.annotate("stopped")
+	cs.Task.Drain()
+	return nil
+}
+
+func (cs *ControlServer) handleAnnotate(r *http.Request) error {
+	text := r.FormValue("text")
+
+	if text == "" {
+		return fmt.Errorf("text is empty")
+	}
+
+	cs.annotate("%s", text)
+
+	return nil
+}
@@ -0,0 +1,161 @@
+package qrn
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+func controlRequest(t *testing.T, server *httptest.Server, method string, path string, code int) *ControlStatus {
+	t.Helper()
+	req, err := http.NewRequest(method, server.URL+path, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := server.Client().Do(req)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode != code {
+		t.Fatalf("%s %s: expected status %d, got %d", method, path, code, res.StatusCode)
+	}
+
+	if code != http.StatusOK {
+		return nil
+	}
+
+	status := &ControlStatus{}
+	err = jsoniter.NewDecoder(res.Body).Decode(status)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return status
+}
+
+func TestControlServer(t *testing.T) {
+	task := NewTask(&TaskOptions{
+		DSNs:    Strings{"fake:latency=1ms"},
+		Files:   Strings{testData(t, "select 1")},
+		Key:     "query",
+		Loop:    true,
+		NAgents: 1,
+		Logger:  &Logger{Null: true},
+	})
+
+	err := task.Prepare()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cs := NewControlServer(task)
+	server := httptest.NewServer(cs.server.Handler)
+	defer server.Close()
+
+	type result struct {
+		recorder *Recorder
+		err      error
+	}
+
+	done := make(chan result)
+
+	go func() {
+		recorder, err := task.Run(0, 10*time.Millisecond, func(*Recorder, int) {})
+		done <- result{recorder, err}
+	}()
+
+	for task.Recorder() == nil {
+		time.Sleep(time.Millisecond)
+	}
+
+	status := controlRequest(t, server, http.MethodGet, "/status", http.StatusOK)
+
+	if status.NAgents != 1 || status.Paused || status.Started.IsZero() {
+		t.Errorf("unexpected status: %+v", status)
+	}
+
+	controlRequest(t, server, http.MethodGet, "/rate?value=100", http.StatusMethodNotAllowed)
+	controlRequest(t, server, http.MethodPost, "/rate?value=x", http.StatusBadRequest)
+	controlRequest(t, server, http.MethodPost, "/rate?value=-1", http.StatusBadRequest)
+	controlRequest(t, server, http.MethodPost, "/agents?value=0", http.StatusBadRequest)
+	controlRequest(t, server, http.MethodPost, "/annotate", http.StatusBadRequest)
+
+	if status := controlRequest(t, server, http.MethodPost, "/rate?value=100", http.StatusOK); status.Rate != 100 {
+		t.Errorf("expected rate 100, got %d", status.Rate)
+	}
+
+	if status := controlRequest(t, server, http.MethodPost, "/agents?value=3", http.StatusOK); status.NAgents != 3 {
+		t.Errorf("expected 3 agents, got %d", status.NAgents)
+	}
+
+	if status := controlRequest(t, server, http.MethodPost, "/pause", http.StatusOK); !status.Paused {
+		t.Error("expected the task to be paused")
+	}
+
+	if status := controlRequest(t, server, http.MethodPost, "/resume", http.StatusOK); status.Paused {
+		t.Error("expected the task to be resumed")
+	}
+
+	controlRequest(t, server, http.MethodPost, "/annotate?text=failover", http.StatusOK)
+	controlRequest(t, server, http.MethodPost, "/stop", http.StatusOK)
+
+	var r result
+
+	select {
+	case r = <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("/stop did not stop the run")
+	}
+
+	if r.err != nil {
+		t.Fatal(r.err)
+	}
+
+	report := r.recorder.Report()
+
+	if report.Interrupted {
+		t.Error("expected the report not to be marked as interrupted")
+	}
+
+	texts := []string{}
+
+	for _, v := range report.Annotations {
+		texts = append(texts, v.Text)
+	}
+
+	expected := "rate changed to 100,agents changed to 3,paused,resumed,failover,stopped"
+
+	if strings.Join(texts, ",") != expected {
+		t.Errorf("expected annotations %q, got %q", expected, strings.Join(texts, ","))
+	}
+}
+
+func TestLoopbackAddr(t *testing.T) {
+	tests := []struct {
+		addr     string
+		expected string
+	}{
+		{":8080", "127.0.0.1:8080"},
+		{"0.0.0.0:8080", "0.0.0.0:8080"},
+		{"localhost:8080", "localhost:8080"},
+		{"[::]:8080", "[::]:8080"},
+	}
+
+	for _, tt := range tests {
+		if addr := loopbackAddr(tt.addr); addr != tt.expected {
+			t.Errorf("loopbackAddr(%q): expected %q, got %q", tt.addr, tt.expected, addr)
+		}
+	}
+}
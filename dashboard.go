@@ -0,0 +1,280 @@
+package qrn
+
+import (
+	"html/template"
+	"io"
+	"math"
+	"os"
+	"runtime"
+	"sort"
+	"time"
+)
+
+// MaxDashboardWindows is the maximum number of time windows in the charts of the dashboard.
+// The window is widened from 1s for longer runs.
+const MaxDashboardWindows = 600
+
+// DashboardBucketsPerDecade is the resolution of the log-scale latency histogram and heatmap.
+const DashboardBucketsPerDecade = 10
+
+type dashboardAnnotation struct {
+	Time int64
+	Text string
+}
+
+type dashboardQuery struct {
+	Fingerprint string
+	Queries     int
+	QPS         float64
+	Errors      int
+	Latency     map[string]float64
+}
+
+type dashboardData struct {
+	Title        string
+	CommandLine  string
+	Meta         [][2]string
+	Interval     float64
+	Times        []int64
+	QPS          []float64
+	P50          []float64
+	P95          []float64
+	P99          []float64
+	Max          []float64
+	Agents       []int
+	Errors       map[string][]int
+	Bounds       []float64
+	Histogram    []int
+	Heatmap      [][]int
+	Annotations  []dashboardAnnotation
+	Queries      []dashboardQuery
+	Latencies    []string
+	Interrupted  bool
+	AssertFailed bool
+}
+
+func msec(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+func percentileOf(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+
+	return sorted[int(math.Ceil(float64(len(sorted))*p))-1]
+}
+
+// latencyBounds returns the upper bounds (ms) of log-scale buckets covering [min, max].
+func latencyBounds(min time.Duration, max time.Duration) []float64 {
+	if min <= 0 {
+		min = time.Microsecond
+	}
+
+	low := math.Floor(math.Log10(msec(min)) * DashboardBucketsPerDecade)
+	high := math.Ceil(math.Log10(msec(max)) * DashboardBucketsPerDecade)
+
+	if high <= low {
+		high = low + 1
+	}
+
+	bounds := []float64{}
+
+	for i := low + 1; i <= high; i++ {
+		bounds = append(bounds, math.Pow(10, i/DashboardBucketsPerDecade))
+	}
+
+	return bounds
+}
+
+func bucketOf(bounds []float64, d time.Duration) int {
+	i := sort.SearchFloat64s(bounds, msec(d))
+
+	if i >= len(bounds) {
+		i = len(bounds) - 1
+	}
+
+	return i
+}
+
+func (recorder *Recorder) dashboardData(report *RecordReport, title string, commandLine string) *dashboardData {
+	data := &dashboardData{
+		Title:        title,
+		CommandLine:  commandLine,
+		Errors:       map[string][]int{},
+		Latencies:    ComparedLatencies,
+		Interrupted:  report.Interrupted,
+		AssertFailed: report.AssertionFailed(),
+	}
+
+	data.Meta = [][2]string{
+		{"Started", recorder.Started.Format(time.RFC3339)},
+		{"Finished", recorder.Finished.Format(time.RFC3339)},
+		{"Elapsed", recorder.Finished.Sub(recorder.Started).Round(time.Millisecond).String()},
+		{"Agents", formatInt(report.NAgents)},
+		{"Max agents", formatInt(report.MaxAgents)},
+		{"Rate", formatInt(report.Rate)},
+		{"Queries", formatInt(report.Queries)},
+		{"QPS", formatFloat(math.Round(report.QPS*10) / 10)},
+		{"Errors", formatInt(report.Errors)},
+		{"Token", report.Token},
+		{"GOMAXPROCS", formatInt(report.GOMAXPROCS)},
+		{"Go", runtime.Version()},
+	}
+
+	for _, f := range report.Files {
+		data.Meta = append(data.Meta, [2]string{"File", f})
+	}
+
+	for _, a := range report.Assertions {
+		result := "PASS"
+
+		if !a.Passed {
+			result = "FAIL"
+		}
+
+		data.Meta = append(data.Meta, [2]string{"Assertion", a.Assertion + " (" + a.Actual + ") " + result})
+	}
+
+	for _, a := range recorder.Annotations {
+		data.Annotations = append(data.Annotations, dashboardAnnotation{Time: a.Time.UnixNano() / int64(time.Millisecond), Text: a.Text})
+	}
+
+	for _, q := range report.Fingerprints {
+		dq := dashboardQuery{Fingerprint: q.Fingerprint, Queries: q.Queries, QPS: q.QPS, Errors: q.Errors, Latency: map[string]float64{}}
+
+		for k, v := range q.Latency {
+			dq.Latency[k] = msec(v)
+		}
+
+		data.Queries = append(data.Queries, dq)
+	}
+
+	elapsed := recorder.Finished.Sub(recorder.Started)
+
+	if elapsed <= 0 {
+		return data
+	}
+
+	interval := time.Second
+
+	if n := elapsed / time.Second; n > MaxDashboardWindows {
+		interval = time.Duration(math.Ceil(float64(n)/MaxDashboardWindows)) * time.Second
+	}
+
+	data.Interval = interval.Seconds()
+	nWindows := int((elapsed + interval - 1) / interval)
+	window := func(t time.Time) int {
+		i := int(t.Sub(recorder.Started) / interval)
+
+		if i < 0 {
+			i = 0
+		} else if i >= nWindows {
+			i = nWindows - 1
+		}
+
+		return i
+	}
+
+	responseTimes := recorder.latencies()
+	windows := make([][]time.Duration, nWindows)
+	var min, max time.Duration
+
+	for i, v := range responseTimes {
+		w := window(v.Time)
+		windows[w] = append(windows[w], v.ResponseTime)
+
+		if i == 0 || v.ResponseTime < min {
+			min = v.ResponseTime
+		}
+
+		if v.ResponseTime > max {
+			max = v.ResponseTime
+		}
+	}
+
+	if len(responseTimes) > 0 {
+		data.Bounds = latencyBounds(min, max)
+		data.Histogram = make([]int, len(data.Bounds))
+	}
+
+	for i, durations := range windows {
+		start := recorder.Started.Add(time.Duration(i) * interval)
+		end := start.Add(interval)
+
+		if end.After(recorder.Finished) {
+			end = recorder.Finished
+		}
+
+		sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+		data.Times = append(data.Times, start.UnixNano()/int64(time.Millisecond))
+		data.QPS = append(data.QPS, float64(len(durations))*float64(time.Second)/float64(end.Sub(start)))
+		data.P50 = append(data.P50, msec(percentileOf(durations, 0.5)))
+		data.P95 = append(data.P95, msec(percentileOf(durations, 0.95)))
+		data.P99 = append(data.P99, msec(percentileOf(durations, 0.99)))
+		data.Agents = append(data.Agents, recorder.maxConcurrency(start, end))
+
+		if len(durations) > 0 {
+			data.Max = append(data.Max, msec(durations[len(durations)-1]))
+		} else {
+			data.Max = append(data.Max, 0)
+		}
+
+		if data.Bounds != nil {
+			row := make([]int, len(data.Bounds))
+
+			for _, d := range durations {
+				b := bucketOf(data.Bounds, d)
+				row[b]++
+				data.Histogram[b]++
+			}
+
+			data.Heatmap = append(data.Heatmap, row)
+		}
+	}
+
+	for _, v := range recorder.ErrorPoints {
+		counts, ok := data.Errors[v.Error]
+
+		if !ok {
+			counts = make([]int, nWindows)
+			data.Errors[v.Error] = counts
+		}
+
+		counts[window(v.Time)]++
+	}
+
+	return data
+}
+
+func formatInt(n int) string {
+	return formatFloat(float64(n))
+}
+
+// WriteDashboard writes a self-contained HTML report of the run with the data embedded.
+func (recorder *Recorder) WriteDashboard(w io.Writer, report *RecordReport, title string, commandLine string) error {
+	tmpl, err := template.New("dashboard").Parse(dashboardTemplate)
+
+	if err != nil {
+		return err
+	}
+
+	return tmpl.Execute(w, recorder.dashboardData(report, title, commandLine))
+}
+
+func (recorder *Recorder) WriteDashboardFile(fname string, report *RecordReport, title string, commandLine string) error {
+	file, err := os.OpenFile(fname, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+	err = recorder.WriteDashboard(file, report, title, commandLine)
+
+	if err != nil {
+		return err
+	}
+
+	return file.Close()
+}
@@ -0,0 +1,398 @@
+package qrn
+
+// dashboardTemplate is the HTML of WriteDashboard. Charts are drawn on canvases without external libraries.
+const dashboardTemplate = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; margin: 0; color: #222; background: #f5f6f8; }
+header { background: #263238; color: #fff; padding: 12px 24px; }
+header h1 { font-size: 18px; margin: 0; }
+header .status { font-size: 13px; margin-top: 4px; color: #b0bec5; }
+header .status.fail { color: #ff8a80; }
+main { padding: 16px 24px; display: grid; grid-template-columns: 1fr 1fr; gap: 16px; }
+section { background: #fff; border-radius: 4px; box-shadow: 0 1px 2px rgba(0,0,0,.15); padding: 12px 16px; min-width: 0; }
+section.wide { grid-column: 1 / 3; }
+h2 { font-size: 14px; margin: 0 0 8px; color: #455a64; }
+canvas { width: 100%; height: 260px; display: block; }
+table { border-collapse: collapse; width: 100%; font-size: 13px; }
+th, td { padding: 4px 8px; border-bottom: 1px solid #eceff1; text-align: right; white-space: nowrap; }
+th { cursor: pointer; color: #455a64; user-select: none; }
+th:first-child, td:first-child { text-align: left; }
+td.query { font-family: Menlo, Consolas, monospace; white-space: normal; word-break: break-all; text-align: left; }
+table.meta td { text-align: left; }
+table.meta td:first-child { color: #607d8b; width: 120px; }
+pre { white-space: pre-wrap; word-break: break-all; background: #eceff1; padding: 8px; margin: 0 0 8px; font-size: 12px; }
+.legend { font-size: 12px; margin-top: 4px; }
+.legend span { cursor: pointer; margin-right: 12px; }
+.legend span.off { opacity: .35; }
+.legend i { display: inline-block; width: 10px; height: 10px; margin-right: 4px; vertical-align: middle; }
+#tooltip { position: fixed; pointer-events: none; background: rgba(38,50,56,.92); color: #fff; font-size: 12px; padding: 6px 8px; border-radius: 3px; display: none; white-space: pre; z-index: 10; }
+.empty { color: #90a4ae; font-size: 13px; }
+</style>
+</head>
+<body>
+<header>
+<h1>{{.Title}}</h1>
+<div class="status" id="status"></div>
+</header>
+<main>
+<section class="wide"><h2>Run</h2><pre id="cmdline"></pre><table class="meta" id="meta"></table></section>
+<section><h2>Throughput (qps)</h2><canvas id="qps"></canvas><div class="legend" id="qps-legend"></div></section>
+<section><h2>Latency percentiles (ms)</h2><canvas id="latency"></canvas><div class="legend" id="latency-legend"></div></section>
+<section><h2>Latency histogram</h2><canvas id="histogram"></canvas></section>
+<section><h2>Latency heatmap (ms)</h2><canvas id="heatmap"></canvas></section>
+<section class="wide"><h2>Errors</h2><canvas id="errors"></canvas><div class="legend" id="errors-legend"></div></section>
+<section class="wide"><h2>Queries</h2><table id="queries"></table></section>
+</main>
+<div id="tooltip"></div>
+<script>
+(function() {
+  const data = {{.}};
+  const palette = ["#1e88e5", "#43a047", "#fb8c00", "#e53935", "#8e24aa", "#00acc1", "#6d4c41", "#546e7a"];
+  const tooltip = document.getElementById("tooltip");
+  const times = data.Times || [];
+  const t0 = times.length > 0 ? times[0] : 0;
+
+  function fmt(v) {
+    if (v === null || v === undefined) return "";
+    if (Math.abs(v) >= 1000) return Math.round(v).toLocaleString();
+    if (Math.abs(v) >= 10) return v.toFixed(1);
+    return Number(v.toPrecision(3)).toString();
+  }
+
+  function fmtElapsed(ms) {
+    const s = Math.round((ms - t0) / 1000);
+    const m = Math.floor(s / 60);
+    return m > 0 ? m + "m" + String(s % 60).padStart(2, "0") + "s" : s + "s";
+  }
+
+  function showTooltip(ev, text) {
+    tooltip.textContent = text;
+    tooltip.style.display = "block";
+    tooltip.style.left = Math.min(ev.clientX + 12, window.innerWidth - tooltip.offsetWidth - 4) + "px";
+    tooltip.style.top = (ev.clientY + 12) + "px";
+  }
+
+  function hideTooltip() {
+    tooltip.style.display = "none";
+  }
+
+  function setup(canvas) {
+    const ratio = window.devicePixelRatio || 1;
+    const w = canvas.clientWidth, h = canvas.clientHeight;
+    canvas.width = w * ratio;
+    canvas.height = h * ratio;
+    const ctx = canvas.getContext("2d");
+    ctx.setTransform(ratio, 0, 0, ratio, 0, 0);
+    ctx.font = "11px sans-serif";
+    return {ctx: ctx, w: w, h: h, left: 52, right: w - 12, top: 10, bottom: h - 22};
+  }
+
+  function niceMax(v) {
+    if (!(v > 0)) return 1;
+    const p = Math.pow(10, Math.floor(Math.log10(v)));
+    for (const m of [1, 2, 2.5, 5, 10]) {
+      if (v <= m * p) return m * p;
+    }
+    return 10 * p;
+  }
+
+  function yAxis(c, max) {
+    const ctx = c.ctx;
+    ctx.strokeStyle = "#eceff1";
+    ctx.fillStyle = "#78909c";
+    ctx.textAlign = "right";
+    ctx.textBaseline = "middle";
+    for (let i = 0; i <= 4; i++) {
+      const y = c.bottom - (c.bottom - c.top) * i / 4;
+      ctx.beginPath();
+      ctx.moveTo(c.left, y);
+      ctx.lineTo(c.right, y);
+      ctx.stroke();
+      ctx.fillText(fmt(max * i / 4), c.left - 6, y);
+    }
+  }
+
+  function xAxis(c, n, x) {
+    const ctx = c.ctx;
+    const step = Math.max(1, Math.ceil(n / Math.max(1, Math.floor((c.right - c.left) / 70))));
+    ctx.fillStyle = "#78909c";
+    ctx.textAlign = "center";
+    ctx.textBaseline = "top";
+    for (let i = 0; i < n; i += step) {
+      ctx.fillText(fmtElapsed(times[i]), x(i), c.bottom + 6);
+    }
+  }
+
+  function annotations(c, x) {
+    const ctx = c.ctx;
+    const interval = data.Interval * 1000;
+    ctx.save();
+    ctx.setLineDash([4, 3]);
+    ctx.strokeStyle = "#8e24aa";
+    ctx.fillStyle = "#8e24aa";
+    ctx.textAlign = "left";
+    ctx.textBaseline = "top";
+    for (const a of data.Annotations || []) {
+      const px = x((a.Time - t0) / interval);
+      ctx.beginPath();
+      ctx.moveTo(px, c.top);
+      ctx.lineTo(px, c.bottom);
+      ctx.stroke();
+      ctx.fillText(a.Text, px + 3, c.top);
+    }
+    ctx.restore();
+  }
+
+  function legend(id, series, redraw) {
+    const el = document.getElementById(id);
+    el.innerHTML = "";
+    series.forEach(function(s) {
+      const span = document.createElement("span");
+      const mark = document.createElement("i");
+      mark.style.background = s.color;
+      span.appendChild(mark);
+      span.appendChild(document.createTextNode(s.name));
+      span.onclick = function() {
+        s.hidden = !s.hidden;
+        span.classList.toggle("off", s.hidden);
+        redraw();
+      };
+      el.appendChild(span);
+    });
+  }
+
+  // timeChart draws series over the time windows as lines, or as stacked bars.
+  function timeChart(id, series, stacked) {
+    const canvas = document.getElementById(id);
+    const n = times.length;
+
+    function draw() {
+      const c = setup(canvas);
+      const ctx = c.ctx;
+      const visible = series.filter(function(s) { return !s.hidden; });
+      let max = 0;
+      for (let i = 0; i < n; i++) {
+        let sum = 0;
+        for (const s of visible) {
+          sum = stacked ? sum + s.values[i] : Math.max(sum, s.values[i]);
+        }
+        max = Math.max(max, sum);
+      }
+      max = niceMax(max);
+      const slot = (c.right - c.left) / Math.max(1, n);
+      const x = function(i) { return c.left + slot * (i + 0.5); };
+      const y = function(v) { return c.bottom - (c.bottom - c.top) * v / max; };
+      yAxis(c, max);
+      xAxis(c, n, x);
+      if (stacked) {
+        const base = new Array(n).fill(0);
+        for (const s of visible) {
+          ctx.fillStyle = s.color;
+          for (let i = 0; i < n; i++) {
+            if (s.values[i] > 0) {
+              ctx.fillRect(x(i) - slot * 0.4, y(base[i] + s.values[i]), slot * 0.8, y(base[i]) - y(base[i] + s.values[i]));
+              base[i] += s.values[i];
+            }
+          }
+        }
+      } else {
+        for (const s of visible) {
+          ctx.strokeStyle = s.color;
+          ctx.lineWidth = 1.5;
+          ctx.beginPath();
+          for (let i = 0; i < n; i++) {
+            if (i === 0) ctx.moveTo(x(i), y(s.values[i])); else ctx.lineTo(x(i), y(s.values[i]));
+          }
+          ctx.stroke();
+        }
+      }
+      annotations(c, x);
+      canvas.onmousemove = function(ev) {
+        const rect = canvas.getBoundingClientRect();
+        const i = Math.floor((ev.clientX - rect.left - c.left) / slot);
+        if (i < 0 || i >= n) { hideTooltip(); return; }
+        const lines = [fmtElapsed(times[i]) + " (" + new Date(times[i]).toLocaleTimeString() + ")"];
+        for (const s of visible) lines.push(s.name + ": " + fmt(s.values[i]));
+        showTooltip(ev, lines.join("\n"));
+      };
+      canvas.onmouseleave = hideTooltip;
+    }
+
+    series.forEach(function(s, i) { s.color = s.color || palette[i % palette.length]; });
+    legend(id + "-legend", series, draw);
+    draw();
+    window.addEventListener("resize", draw);
+  }
+
+  function histogram() {
+    const canvas = document.getElementById("histogram");
+    const counts = data.Histogram || [];
+    const bounds = data.Bounds || [];
+
+    function draw() {
+      const c = setup(canvas);
+      const ctx = c.ctx;
+      const max = niceMax(Math.max.apply(null, counts.concat([0])));
+      const slot = (c.right - c.left) / Math.max(1, counts.length);
+      yAxis(c, max);
+      ctx.fillStyle = "#1e88e5";
+      counts.forEach(function(v, i) {
+        const h = (c.bottom - c.top) * v / max;
+        ctx.fillRect(c.left + slot * i + 1, c.bottom - h, Math.max(1, slot - 2), h);
+      });
+      ctx.fillStyle = "#78909c";
+      ctx.textAlign = "center";
+      ctx.textBaseline = "top";
+      const step = Math.max(1, Math.ceil(counts.length / Math.max(1, Math.floor((c.right - c.left) / 50))));
+      for (let i = 0; i < bounds.length; i += step) {
+        ctx.fillText(fmt(bounds[i]) + "ms", c.left + slot * (i + 1), c.bottom + 6);
+      }
+      canvas.onmousemove = function(ev) {
+        const rect = canvas.getBoundingClientRect();
+        const i = Math.floor((ev.clientX - rect.left - c.left) / slot);
+        if (i < 0 || i >= counts.length) { hideTooltip(); return; }
+        const low = i > 0 ? bounds[i - 1] : 0;
+        showTooltip(ev, fmt(low) + "ms - " + fmt(bounds[i]) + "ms\n" + counts[i].toLocaleString() + " queries");
+      };
+      canvas.onmouseleave = hideTooltip;
+    }
+
+    draw();
+    window.addEventListener("resize", draw);
+  }
+
+  function heatmap() {
+    const canvas = document.getElementById("heatmap");
+    const rows = data.Heatmap || [];
+    const bounds = data.Bounds || [];
+
+    function color(v, max) {
+      if (v === 0) return "#ffffff";
+      const r = Math.log(v + 1) / Math.log(max + 1);
+      const hue = 220 - 220 * r;
+      return "hsl(" + hue + ", 80%, " + (85 - 40 * r) + "%)";
+    }
+
+    function draw() {
+      const c = setup(canvas);
+      const ctx = c.ctx;
+      let max = 0;
+      for (const row of rows) for (const v of row) max = Math.max(max, v);
+      const cw = (c.right - c.left) / Math.max(1, rows.length);
+      const ch = (c.bottom - c.top) / Math.max(1, bounds.length);
+      rows.forEach(function(row, i) {
+        row.forEach(function(v, j) {
+          ctx.fillStyle = color(v, max);
+          ctx.fillRect(c.left + cw * i, c.bottom - ch * (j + 1), Math.ceil(cw), Math.ceil(ch));
+        });
+      });
+      ctx.fillStyle = "#78909c";
+      ctx.textAlign = "right";
+      ctx.textBaseline = "middle";
+      const step = Math.max(1, Math.ceil(bounds.length / 8));
+      for (let j = 0; j < bounds.length; j += step) {
+        ctx.fillText(fmt(bounds[j]), c.left - 6, c.bottom - ch * (j + 1));
+      }
+      xAxis(c, rows.length, function(i) { return c.left + cw * (i + 0.5); });
+      canvas.onmousemove = function(ev) {
+        const rect = canvas.getBoundingClientRect();
+        const i = Math.floor((ev.clientX - rect.left - c.left) / cw);
+        const j = Math.floor((c.bottom - (ev.clientY - rect.top)) / ch);
+        if (i < 0 || i >= rows.length || j < 0 || j >= bounds.length) { hideTooltip(); return; }
+        const low = j > 0 ? bounds[j - 1] : 0;
+        showTooltip(ev, fmtElapsed(times[i]) + "\n" + fmt(low) + "ms - " + fmt(bounds[j]) + "ms\n" + rows[i][j].toLocaleString() + " queries");
+      };
+      canvas.onmouseleave = hideTooltip;
+    }
+
+    draw();
+    window.addEventListener("resize", draw);
+  }
+
+  function queries() {
+    const table = document.getElementById("queries");
+    const rows = data.Queries || [];
+    const columns = [
+      {name: "Query", value: function(q) { return q.Fingerprint; }},
+      {name: "Queries", value: function(q) { return q.Queries; }},
+      {name: "QPS", value: function(q) { return q.QPS; }},
+      {name: "Errors", value: function(q) { return q.Errors; }}
+    ];
+    (data.Latencies || []).forEach(function(name) {
+      columns.push({name: name + " (ms)", value: function(q) { return q.Latency ? q.Latency[name] : null; }});
+    });
+    let sortBy = 1, desc = true;
+
+    function render() {
+      const sorted = rows.slice().sort(function(a, b) {
+        const va = columns[sortBy].value(a), vb = columns[sortBy].value(b);
+        const r = va < vb ? -1 : va > vb ? 1 : 0;
+        return desc ? -r : r;
+      });
+      table.innerHTML = "";
+      const head = table.insertRow();
+      columns.forEach(function(col, i) {
+        const th = document.createElement("th");
+        th.textContent = col.name + (i === sortBy ? (desc ? " ▼" : " ▲") : "");
+        th.onclick = function() {
+          desc = sortBy === i ? !desc : i > 0;
+          sortBy = i;
+          render();
+        };
+        head.appendChild(th);
+      });
+      for (const q of sorted) {
+        const tr = table.insertRow();
+        columns.forEach(function(col, i) {
+          const td = tr.insertCell();
+          const v = col.value(q);
+          td.textContent = i === 0 ? v : fmt(v);
+          if (i === 0) td.className = "query";
+        });
+      }
+      if (rows.length === 0) {
+        const td = table.insertRow().insertCell();
+        td.className = "empty";
+        td.textContent = "no queries";
+      }
+    }
+
+    render();
+  }
+
+  document.getElementById("cmdline").textContent = data.CommandLine;
+  const meta = document.getElementById("meta");
+  for (const kv of data.Meta || []) {
+    const tr = meta.insertRow();
+    tr.insertCell().textContent = kv[0];
+    tr.insertCell().textContent = kv[1];
+  }
+  const status = document.getElementById("status");
+  status.textContent = data.Interrupted ? "interrupted" : data.AssertFailed ? "assertion failed" : "completed";
+  status.classList.toggle("fail", data.Interrupted || data.AssertFailed);
+
+  timeChart("qps", [
+    {name: "qps", values: data.QPS || []},
+    {name: "agents", values: data.Agents || [], color: "#b0bec5"}
+  ], false);
+  timeChart("latency", [
+    {name: "p50", values: data.P50 || []},
+    {name: "p95", values: data.P95 || []},
+    {name: "p99", values: data.P99 || []},
+    {name: "max", values: data.Max || [], hidden: true}
+  ], false);
+  const errors = Object.keys(data.Errors || {}).sort().map(function(k) { return {name: k, values: data.Errors[k]}; });
+  timeChart("errors", errors, true);
+  document.querySelectorAll("#latency-legend span")[3].classList.add("off");
+  histogram();
+  heatmap();
+  queries();
+})();
+</script>
+</body>
+</html>
+`
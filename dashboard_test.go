@@ -0,0 +1,151 @@
+package qrn
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLatencyBounds(t *testing.T) {
+	bounds := latencyBounds(time.Millisecond, 10*time.Millisecond)
+
+	if len(bounds) != DashboardBucketsPerDecade {
+		t.Fatalf("expected %d buckets for a decade, got %d", DashboardBucketsPerDecade, len(bounds))
+	}
+
+	if last := bounds[len(bounds)-1]; last < 9.999 || last > 10.001 {
+		t.Errorf("expected the last bound to be 10ms, got %g", last)
+	}
+
+	tests := []struct {
+		d        time.Duration
+		expected int
+	}{
+		{time.Millisecond, 0},
+		{1100 * time.Microsecond, 0},
+		{1300 * time.Microsecond, 1},
+		{1600 * time.Microsecond, 2},
+		{10 * time.Millisecond, len(bounds) - 1},
+		// clamped to the last bucket
+		{time.Second, len(bounds) - 1},
+	}
+
+	for _, tt := range tests {
+		if b := bucketOf(bounds, tt.d); b != tt.expected {
+			t.Errorf("bucketOf(%s): expected %d, got %d", tt.d, tt.expected, b)
+		}
+	}
+
+	// a single latency has a bucket
+	if bounds := latencyBounds(time.Millisecond, time.Millisecond); len(bounds) != 1 {
+		t.Errorf("expected a bucket, got %v", bounds)
+	}
+}
+
+func TestDashboardData(t *testing.T) {
+	t0 := time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC)
+	at := func(d time.Duration) time.Time { return t0.Add(d) }
+
+	recorder := &Recorder{
+		Started:  t0,
+		Finished: at(2500 * time.Millisecond),
+		ResponseTimes: []DataPoint{
+			{Time: at(100 * time.Millisecond), ResponseTime: time.Millisecond},
+			{Time: at(200 * time.Millisecond), ResponseTime: 3 * time.Millisecond},
+			{Time: at(1500 * time.Millisecond), ResponseTime: 2 * time.Millisecond},
+			{Time: at(2100 * time.Millisecond), ResponseTime: 5 * time.Millisecond},
+		},
+		ErrorPoints: []DataPoint{
+			{Time: at(1200 * time.Millisecond), Error: ErrorClassTimeout},
+			{Time: at(1300 * time.Millisecond), Error: ErrorClassTimeout},
+		},
+		Concurrency: []ConcurrencyPoint{
+			{Time: t0, NAgents: 2},
+			{Time: at(1800 * time.Millisecond), NAgents: 4},
+		},
+		Annotations: []Annotation{{Time: at(time.Second), Text: "failover"}},
+	}
+
+	data := recorder.dashboardData(&RecordReport{NAgents: 2, Queries: 4}, "title", "qrn -nagents 2")
+
+	if data.Interval != 1 || len(data.Times) != 3 {
+		t.Fatalf("expected 3 windows of 1s, got %d windows of %gs", len(data.Times), data.Interval)
+	}
+
+	// the last window is partial
+	if expected := []float64{2, 1, 2}; !reflect.DeepEqual(data.QPS, expected) {
+		t.Errorf("expected QPS %v, got %v", expected, data.QPS)
+	}
+
+	if expected := []float64{3, 2, 5}; !reflect.DeepEqual(data.Max, expected) {
+		t.Errorf("expected max %v, got %v", expected, data.Max)
+	}
+
+	if expected := []int{2, 4, 4}; !reflect.DeepEqual(data.Agents, expected) {
+		t.Errorf("expected agents %v, got %v", expected, data.Agents)
+	}
+
+	if expected := map[string][]int{ErrorClassTimeout: {0, 2, 0}}; !reflect.DeepEqual(data.Errors, expected) {
+		t.Errorf("expected errors %v, got %v", expected, data.Errors)
+	}
+
+	total := 0
+
+	for i, row := range data.Heatmap {
+		n := 0
+
+		for _, v := range row {
+			n += v
+		}
+
+		if n != []int{2, 1, 1}[i] {
+			t.Errorf("unexpected heatmap row %d: %v", i, row)
+		}
+
+		total += n
+	}
+
+	if total != 4 || len(data.Histogram) != len(data.Bounds) {
+		t.Errorf("unexpected histogram: %v (bounds %v)", data.Histogram, data.Bounds)
+	}
+
+	if len(data.Annotations) != 1 || data.Annotations[0].Time != at(time.Second).UnixNano()/int64(time.Millisecond) {
+		t.Errorf("unexpected annotations: %+v", data.Annotations)
+	}
+}
+
+func TestWriteDashboard(t *testing.T) {
+	t0 := time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	recorder := &Recorder{
+		Started:       t0,
+		Finished:      t0.Add(time.Second),
+		ResponseTimes: []DataPoint{{Time: t0, ResponseTime: time.Millisecond}},
+		Annotations:   []Annotation{{Time: t0, Text: "</script><b>"}},
+	}
+
+	var buf bytes.Buffer
+	err := recorder.WriteDashboard(&buf, &RecordReport{Queries: 1}, "<qrn>", "qrn")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	html := buf.String()
+
+	if !strings.Contains(html, "<title>&lt;qrn&gt;</title>") {
+		t.Error("expected the escaped title")
+	}
+
+	// the embedded data does not close the script
+	if strings.Count(html, "</script>") != 1 {
+		t.Error("expected the annotation to be escaped in the script")
+	}
+
+	// the dashboard is self-contained
+	if strings.Contains(html, "src=") || strings.Contains(html, "http://") || strings.Contains(html, "https://") {
+		t.Error("expected no external resources")
+	}
+}
@@ -22,9 +22,45 @@ type Data struct {
 	Rate       int
 	MaxCount   int64
 	CommitRate int64
+	Throttle   *Throttle
+	TimeoutKey string
+	Seed       int64
 }
 
-func (data *Data) EachLine(block func(string) (bool, error)) (int64, error) {
+func rateToLimit(rate int) time.Duration {
+	if rate > 0 {
+		return time.Second / time.Duration(rate+1)
+	}
+
+	return 0
+}
+
+// parseTimeout returns the timeout of a line. The value is a duration string (e.g. "500ms") or milliseconds.
+func parseTimeout(v *fastjson.Value) (time.Duration, error) {
+	var timeout time.Duration
+
+	switch v.Type() {
+	case fastjson.TypeString:
+		var err error
+		timeout, err = time.ParseDuration(string(v.GetStringBytes()))
+
+		if err != nil {
+			return 0, err
+		}
+	case fastjson.TypeNumber:
+		timeout = time.Duration(v.GetFloat64() * float64(time.Millisecond))
+	default:
+		return 0, fmt.Errorf("invalid timeout: %s", v)
+	}
+
+	if timeout < 0 {
+		return 0, fmt.Errorf("invalid timeout: %s: must be >= 0", v)
+	}
+
+	return timeout, nil
+}
+
+func (data *Data) EachLine(block func(string, time.Duration) (bool, error)) (int64, error) {
 	file, err := os.OpenFile(data.Path, os.O_RDONLY, 0)
 
 	if err != nil {
@@ -41,7 +77,15 @@ func (data *Data) EachLine(block func(string) (bool, error)) (int64, error) {
 		}
 
 		size := fileinfo.Size()
-		offset := rand.Int63n(size)
+		var offset int64
+
+		if data.Seed != 0 {
+			// agents with the same seed read the same lines
+			offset = rand.New(rand.NewSource(data.Seed)).Int63n(size)
+		} else {
+			offset = rand.Int63n(size)
+		}
+
 		_, err = file.Seek(offset, io.SeekStart)
 
 		if err != nil {
@@ -50,12 +94,16 @@ func (data *Data) EachLine(block func(string) (bool, error)) (int64, error) {
 	}
 
 	var parser fastjson.Parser
-	originLimit := time.Duration(0)
+	rate := data.Rate
+	var epoch int64
 
-	if data.Rate > 0 {
-		originLimit = time.Second / time.Duration(data.Rate+1)
+	if data.Throttle != nil {
+		rate = data.Throttle.Rate()
+		epoch = data.Throttle.Epoch()
 	}
 
+	originLimit := rateToLimit(rate)
+
 	reader := bufio.NewReader(file)
 
 	if data.Random {
@@ -84,6 +132,7 @@ func (data *Data) EachLine(block func(string) (bool, error)) (int64, error) {
 		for {
 			line := "/* query inserted by qrn */"
 			var query string
+			var timeout time.Duration
 
 			if nextQuery != "" {
 				query = nextQuery
@@ -112,18 +161,28 @@ func (data *Data) EachLine(block func(string) (bool, error)) (int64, error) {
 					return loopCount, fmt.Errorf("query is empty: key=%s, json=%s", data.Key, rawLine)
 				}
 
+				if data.TimeoutKey != "" {
+					if v := json.Get(data.TimeoutKey); v != nil {
+						timeout, err = parseTimeout(v)
+
+						if err != nil {
+							return loopCount, fmt.Errorf("%w: key=%s, json=%s", err, data.TimeoutKey, rawLine)
+						}
+					}
+				}
+
 				line = string(rawLine)
 				query = string(rawQuery)
 			}
 
-			cont, err := block(query)
+			cont, err := block(query, timeout)
 
 			if !cont || err != nil {
 				if err != nil {
 					errmsg := fmt.Sprintf("key=%s, json=%s", data.Key, line)
 
 					if data.Force {
-						fmt.Fprintf(os.Stderr, "%s: %s", err, errmsg)
+						// the error is recorded by the agent
 						start = time.Now()
 						continue
 					} else {
@@ -141,6 +200,16 @@ func (data *Data) EachLine(block func(string) (bool, error)) (int64, error) {
 				return loopCount, nil
 			}
 
+			if data.Throttle != nil && data.Throttle.Epoch() != epoch {
+				epoch = data.Throttle.Epoch()
+				originLimit = rateToLimit(data.Throttle.Rate())
+				limit = originLimit
+				throttleStart = time.Now()
+				start = throttleStart
+				tx = 0
+				continue
+			}
+
 			select {
 			case <-ticker.C:
 				throttleEnd := time.Now()
@@ -0,0 +1,250 @@
+package qrn
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+// Distribution generates random durations.
+type Distribution interface {
+	Next() time.Duration
+	String() string
+}
+
+type constDist struct {
+	value time.Duration
+}
+
+type uniformDist struct {
+	min time.Duration
+	max time.Duration
+}
+
+type normalDist struct {
+	mean   time.Duration
+	stddev time.Duration
+}
+
+type expDist struct {
+	mean time.Duration
+}
+
+type logNormalDist struct {
+	median time.Duration
+	sigma  float64
+}
+
+// ParseDistribution parses a distribution of durations:
+//
+//	30s                    constant
+//	uniform:10s:60s        uniform between min and max
+//	normal:30s:5s          normal with mean and standard deviation
+//	exp:30s                exponential with mean
+//	lognormal:30s:0.5      log-normal with median and sigma
+//	histogram:report.json  replayed from the latency histogram of a qrn report
+func ParseDistribution(s string) (Distribution, error) {
+	if strings.HasPrefix(s, "histogram:") {
+		return loadHistDist(strings.TrimPrefix(s, "histogram:"))
+	}
+
+	parts := strings.Split(s, ":")
+	args := make([]time.Duration, 0, 2)
+	kind := "const"
+
+	if len(parts) > 1 {
+		kind = parts[0]
+		parts = parts[1:]
+	}
+
+	if kind == "lognormal" {
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid distribution: %s", s)
+		}
+
+		median, err := time.ParseDuration(parts[0])
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid distribution: %s: %w", s, err)
+		}
+
+		var sigma float64
+
+		if _, err := fmt.Sscanf(parts[1], "%g", &sigma); err != nil {
+			return nil, fmt.Errorf("invalid distribution: %s: %w", s, err)
+		}
+
+		if median <= 0 || sigma < 0 {
+			return nil, fmt.Errorf("invalid distribution: %s: the median must be positive and the sigma must not be negative", s)
+		}
+
+		return &logNormalDist{median: median, sigma: sigma}, nil
+	}
+
+	for _, v := range parts {
+		d, err := time.ParseDuration(v)
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid distribution: %s: %w", s, err)
+		}
+
+		if d < 0 {
+			return nil, fmt.Errorf("invalid distribution: %s: negative duration", s)
+		}
+
+		args = append(args, d)
+	}
+
+	switch {
+	case kind == "const" && len(args) == 1:
+		return &constDist{value: args[0]}, nil
+	case kind == "uniform" && len(args) == 2 && args[0] <= args[1]:
+		return &uniformDist{min: args[0], max: args[1]}, nil
+	case kind == "normal" && len(args) == 2:
+		return &normalDist{mean: args[0], stddev: args[1]}, nil
+	case kind == "exp" && len(args) == 1:
+		return &expDist{mean: args[0]}, nil
+	}
+
+	return nil, fmt.Errorf("invalid distribution: %s", s)
+}
+
+func (dist *constDist) Next() time.Duration {
+	return dist.value
+}
+
+func (dist *constDist) String() string {
+	return dist.value.String()
+}
+
+func (dist *uniformDist) Next() time.Duration {
+	return dist.min + time.Duration(rand.Int63n(int64(dist.max-dist.min)+1))
+}
+
+func (dist *uniformDist) String() string {
+	return fmt.Sprintf("uniform:%s:%s", dist.min, dist.max)
+}
+
+func (dist *normalDist) Next() time.Duration {
+	return nonNegative(float64(dist.mean) + rand.NormFloat64()*float64(dist.stddev))
+}
+
+func (dist *normalDist) String() string {
+	return fmt.Sprintf("normal:%s:%s", dist.mean, dist.stddev)
+}
+
+func (dist *expDist) Next() time.Duration {
+	return nonNegative(rand.ExpFloat64() * float64(dist.mean))
+}
+
+func (dist *expDist) String() string {
+	return fmt.Sprintf("exp:%s", dist.mean)
+}
+
+func (dist *logNormalDist) Next() time.Duration {
+	return nonNegative(float64(dist.median) * math.Exp(rand.NormFloat64()*dist.sigma))
+}
+
+func (dist *logNormalDist) String() string {
+	return fmt.Sprintf("lognormal:%s:%g", dist.median, dist.sigma)
+}
+
+func nonNegative(v float64) time.Duration {
+	if v < 0 {
+		return 0
+	}
+
+	return time.Duration(v)
+}
+
+type histBin struct {
+	low   time.Duration
+	high  time.Duration
+	count uint64
+}
+
+type histDist struct {
+	path  string
+	bins  []histBin
+	total uint64
+}
+
+func loadHistDist(path string) (Distribution, error) {
+	raw, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	report := &struct {
+		Response struct {
+			Histogram []map[string]uint64
+		}
+	}{}
+
+	err = json.Unmarshal(raw, report)
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid histogram: %s: %w", path, err)
+	}
+
+	dist := &histDist{path: path}
+
+	for _, bin := range report.Response.Histogram {
+		for k, n := range bin {
+			// e.g. "1.2ms - 2.3ms"
+			parts := strings.Split(k, " - ")
+
+			if len(parts) != 2 {
+				return nil, fmt.Errorf("invalid histogram bin: %s: %s", path, k)
+			}
+
+			low, err := time.ParseDuration(parts[0])
+
+			if err != nil {
+				return nil, fmt.Errorf("invalid histogram bin: %s: %w", path, err)
+			}
+
+			high, err := time.ParseDuration(parts[1])
+
+			if err != nil {
+				return nil, fmt.Errorf("invalid histogram bin: %s: %w", path, err)
+			}
+
+			if high < low {
+				high = low
+			}
+
+			dist.bins = append(dist.bins, histBin{low: low, high: high, count: n})
+			dist.total += n
+		}
+	}
+
+	if dist.total == 0 {
+		return nil, fmt.Errorf("empty histogram: %s", path)
+	}
+
+	return dist, nil
+}
+
+func (dist *histDist) Next() time.Duration {
+	n := uint64(rand.Int63n(int64(dist.total)))
+
+	for _, bin := range dist.bins {
+		if n < bin.count {
+			return bin.low + time.Duration(rand.Int63n(int64(bin.high-bin.low)+1))
+		}
+
+		n -= bin.count
+	}
+
+	return dist.bins[len(dist.bins)-1].high
+}
+
+func (dist *histDist) String() string {
+	return "histogram:" + dist.path
+}
@@ -0,0 +1,102 @@
+package qrn
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseDistribution(t *testing.T) {
+	tests := []struct {
+		s        string
+		expected string
+		min      time.Duration
+		max      time.Duration
+	}{
+		{"30s", "30s", 30 * time.Second, 30 * time.Second},
+		{"uniform:10s:60s", "uniform:10s:1m0s", 10 * time.Second, time.Minute},
+		{"uniform:1s:1s", "uniform:1s:1s", time.Second, time.Second},
+		{"normal:30s:5s", "normal:30s:5s", 0, -1},
+		{"exp:30s", "exp:30s", 0, -1},
+		{"lognormal:2ms:0.5", "lognormal:2ms:0.5", 0, -1},
+		{"lognormal:2ms:0", "lognormal:2ms:0", 2 * time.Millisecond, 2 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			dist, err := ParseDistribution(tt.s)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if dist.String() != tt.expected {
+				t.Errorf("expected %s, got %s", tt.expected, dist.String())
+			}
+
+			for i := 0; i < 1000; i++ {
+				if v := dist.Next(); v < tt.min || (tt.max >= 0 && v > tt.max) {
+					t.Fatalf("out of range: %s", v)
+				}
+			}
+		})
+	}
+}
+
+func TestParseDistributionError(t *testing.T) {
+	tests := []string{
+		"",
+		"abc",
+		"-1s",
+		"uniform:60s:10s",
+		"uniform:10s",
+		"normal:30s",
+		"exp:30s:1s",
+		"lognormal:2ms",
+		"lognormal:2ms:x",
+		"lognormal:0s:0.5",
+		"lognormal:-2ms:0.5",
+		"lognormal:2ms:-0.5",
+		"pareto:1s",
+		"histogram:/nonexistent/report.json",
+	}
+
+	for _, s := range tests {
+		if dist, err := ParseDistribution(s); err == nil {
+			t.Errorf("ParseDistribution(%q): expected an error, got %s", s, dist)
+		}
+	}
+}
+
+func TestParseDistributionHistogram(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "report.json")
+	report := `{"Response":{"Histogram":[{"1ms - 2ms":3},{"2ms - 4ms":1},{"4ms - 8ms":0}]}}`
+	err := ioutil.WriteFile(path, []byte(report), 0644)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dist, err := ParseDistribution("histogram:" + path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 1000; i++ {
+		if v := dist.Next(); v < time.Millisecond || v > 4*time.Millisecond {
+			t.Fatalf("out of range: %s", v)
+		}
+	}
+
+	err = ioutil.WriteFile(path, []byte(`{"Response":{"Histogram":[]}}`), 0644)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ParseDistribution("histogram:" + path); err == nil {
+		t.Error("expected an error for an empty histogram")
+	}
+}
@@ -0,0 +1,81 @@
+package qrn
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"syscall"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgconn"
+	"modernc.org/sqlite"
+)
+
+const (
+	ErrorClassTimeout    = "timeout"
+	ErrorClassConnection = "connection"
+	ErrorClassCanceled   = "canceled"
+	ErrorClassOther      = "other"
+)
+
+// ClassifyError returns the class of a query error:
+// "mysql:<error number>", "postgres:<SQLSTATE>", "sqlite:<result code>", "timeout", "connection", "canceled" or "other".
+func ClassifyError(err error) string {
+	var myErr *mysql.MySQLError
+	var pgErr *pgconn.PgError
+	var liteErr *sqlite.Error
+	var netErr net.Error
+
+	switch {
+	case errors.As(err, &myErr):
+		return fmt.Sprintf("mysql:%d", myErr.Number)
+	case errors.As(err, &pgErr):
+		return "postgres:" + pgErr.Code
+	case errors.As(err, &liteErr):
+		return fmt.Sprintf("sqlite:%d", liteErr.Code())
+	case errors.Is(err, context.DeadlineExceeded), pgconn.Timeout(err):
+		return ErrorClassTimeout
+	case errors.Is(err, context.Canceled):
+		return ErrorClassCanceled
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return ErrorClassTimeout
+	case IsConnectionError(err):
+		return ErrorClassConnection
+	default:
+		return ErrorClassOther
+	}
+}
+
+// IsConnectionError reports whether the error means that the connection to the database is lost or refused.
+func IsConnectionError(err error) bool {
+	var netErr net.Error
+
+	return errors.Is(err, driver.ErrBadConn) ||
+		errors.Is(err, sql.ErrConnDone) ||
+		errors.Is(err, mysql.ErrInvalidConn) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.EPIPE) ||
+		errors.As(err, &netErr)
+}
+
+// IsReconnectable reports whether the error class means that the database is unavailable,
+// e.g. the connection is lost or the server is shutting down during a failover.
+func IsReconnectable(class string) bool {
+	switch class {
+	case ErrorClassConnection, ErrorClassTimeout:
+		return true
+	case "mysql:1053", "mysql:1836", "mysql:1290": // server shutdown, running in read-only mode
+		return true
+	}
+
+	// connection exception, admin shutdown, crash shutdown, cannot connect now
+	return strings.HasPrefix(class, "postgres:08") || strings.HasPrefix(class, "postgres:57P0")
+}
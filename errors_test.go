@@ -0,0 +1,68 @@
+package qrn
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgconn"
+)
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		err      error
+		expected string
+	}{
+		{&mysql.MySQLError{Number: 1062, Message: "Duplicate entry"}, "mysql:1062"},
+		{fmt.Errorf("exec: %w", &mysql.MySQLError{Number: 1213}), "mysql:1213"},
+		{&pgconn.PgError{Code: "23505"}, "postgres:23505"},
+		{context.DeadlineExceeded, ErrorClassTimeout},
+		{fmt.Errorf("query: %w", context.DeadlineExceeded), ErrorClassTimeout},
+		{&net.OpError{Op: "read", Net: "tcp", Err: os.ErrDeadlineExceeded}, ErrorClassTimeout},
+		{context.Canceled, ErrorClassCanceled},
+		{driver.ErrBadConn, ErrorClassConnection},
+		{mysql.ErrInvalidConn, ErrorClassConnection},
+		{io.EOF, ErrorClassConnection},
+		{&net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}, ErrorClassConnection},
+		{fmt.Errorf("write: %w", syscall.EPIPE), ErrorClassConnection},
+		{ErrFake, ErrorClassOther},
+		{errors.New("unknown"), ErrorClassOther},
+	}
+
+	for _, tt := range tests {
+		if class := ClassifyError(tt.err); class != tt.expected {
+			t.Errorf("ClassifyError(%v): expected %s, got %s", tt.err, tt.expected, class)
+		}
+	}
+}
+
+func TestIsReconnectable(t *testing.T) {
+	tests := []struct {
+		class    string
+		expected bool
+	}{
+		{ErrorClassConnection, true},
+		{ErrorClassTimeout, true},
+		{"mysql:1053", true},
+		{"mysql:1290", true},
+		{"postgres:08006", true},
+		{"postgres:57P01", true},
+		{"mysql:1062", false},
+		{"postgres:23505", false},
+		{ErrorClassCanceled, false},
+		{ErrorClassOther, false},
+	}
+
+	for _, tt := range tests {
+		if ok := IsReconnectable(tt.class); ok != tt.expected {
+			t.Errorf("IsReconnectable(%s): expected %v, got %v", tt.class, tt.expected, ok)
+		}
+	}
+}
@@ -0,0 +1,253 @@
+package qrn
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const FakeDriverName = "fake"
+
+// ErrFake is the error returned by the fake driver at its error rate.
+var ErrFake = errors.New("fake error")
+
+// FakeConfig is the behavior of the fake driver, parsed from the DSN:
+//
+//	latency=lognormal:2ms:0.5&error-rate=0.01&stall=uniform:1s:3s&stall-rate=0.001
+//
+// latency and stall accept the same distributions as ParseDistribution.
+type FakeConfig struct {
+	Latency   Distribution
+	ErrorRate float64
+	Stall     Distribution
+	StallRate float64
+}
+
+func ParseFakeDSN(dsn string) (*FakeConfig, error) {
+	values, err := url.ParseQuery(strings.TrimPrefix(dsn, FakeDriverName+":"))
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid fake DSN: %s: %w", dsn, err)
+	}
+
+	config := &FakeConfig{}
+
+	for k := range values {
+		v := values.Get(k)
+
+		switch k {
+		case "latency":
+			config.Latency, err = ParseDistribution(v)
+		case "stall":
+			config.Stall, err = ParseDistribution(v)
+		case "error-rate":
+			config.ErrorRate, err = strconv.ParseFloat(v, 64)
+		case "stall-rate":
+			config.StallRate, err = strconv.ParseFloat(v, 64)
+		default:
+			err = fmt.Errorf("unknown parameter: %s", k)
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid fake DSN: %s: %w", dsn, err)
+		}
+	}
+
+	if config.ErrorRate < 0 || config.ErrorRate > 1 {
+		return nil, fmt.Errorf("invalid fake DSN: %s: 'error-rate' must be between 0 and 1", dsn)
+	}
+
+	if config.StallRate < 0 || config.StallRate > 1 {
+		return nil, fmt.Errorf("invalid fake DSN: %s: 'stall-rate' must be between 0 and 1", dsn)
+	}
+
+	if config.StallRate > 0 && config.Stall == nil {
+		return nil, fmt.Errorf("invalid fake DSN: %s: 'stall' is required for 'stall-rate'", dsn)
+	}
+
+	return config, nil
+}
+
+// fakeDriver is a database driver answering every statement without a database, for dry runs and self-benchmarks.
+type fakeDriver struct{}
+
+// fakeConnector holds the config parsed once for each DSN.
+type fakeConnector struct {
+	driver *fakeDriver
+	config *FakeConfig
+}
+
+type fakeConn struct {
+	config *FakeConfig
+}
+
+type fakeStmt struct {
+	conn  *fakeConn
+	query string
+}
+
+type fakeRows struct {
+	done bool
+}
+
+type fakeResult struct{}
+
+func init() {
+	sql.Register(FakeDriverName, &fakeDriver{})
+}
+
+func (*fakeDriver) Open(dsn string) (driver.Conn, error) {
+	config, err := ParseFakeDSN(dsn)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &fakeConn{config: config}, nil
+}
+
+// OpenConnector parses the DSN once, so that connects do not parse it again.
+func (d *fakeDriver) OpenConnector(dsn string) (driver.Connector, error) {
+	config, err := ParseFakeDSN(dsn)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &fakeConnector{driver: d, config: config}, nil
+}
+
+func (connector *fakeConnector) Connect(context.Context) (driver.Conn, error) {
+	return &fakeConn{config: connector.config}, nil
+}
+
+func (connector *fakeConnector) Driver() driver.Driver {
+	return connector.driver
+}
+
+// answer waits for the latency (and a stall) and returns an error at the error rate.
+func (conn *fakeConn) answer(ctx context.Context) error {
+	config := conn.config
+	var wait time.Duration
+
+	if config.Latency != nil {
+		wait = config.Latency.Next()
+	}
+
+	if config.StallRate > 0 && rand.Float64() < config.StallRate {
+		wait += config.Stall.Next()
+	}
+
+	if wait > 0 {
+		timer := time.NewTimer(wait)
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+
+	if config.ErrorRate > 0 && rand.Float64() < config.ErrorRate {
+		return ErrFake
+	}
+
+	return nil
+}
+
+func (conn *fakeConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	err := conn.answer(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return fakeResult{}, nil
+}
+
+func (conn *fakeConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	err := conn.answer(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &fakeRows{}, nil
+}
+
+func (conn *fakeConn) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (conn *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeStmt{conn: conn, query: query}, nil
+}
+
+func (conn *fakeConn) Begin() (driver.Tx, error) {
+	return conn, nil
+}
+
+func (conn *fakeConn) Commit() error {
+	return nil
+}
+
+func (conn *fakeConn) Rollback() error {
+	return nil
+}
+
+func (conn *fakeConn) Close() error {
+	return nil
+}
+
+func (stmt *fakeStmt) Close() error {
+	return nil
+}
+
+func (stmt *fakeStmt) NumInput() int {
+	return -1
+}
+
+func (stmt *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return stmt.conn.ExecContext(context.Background(), stmt.query, nil)
+}
+
+func (stmt *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return stmt.conn.QueryContext(context.Background(), stmt.query, nil)
+}
+
+// fakeRows is a single row with a single column "1".
+func (rows *fakeRows) Columns() []string {
+	return []string{"1"}
+}
+
+func (rows *fakeRows) Close() error {
+	return nil
+}
+
+func (rows *fakeRows) Next(dest []driver.Value) error {
+	if rows.done {
+		return io.EOF
+	}
+
+	dest[0] = int64(1)
+	rows.done = true
+
+	return nil
+}
+
+func (fakeResult) LastInsertId() (int64, error) {
+	return 0, nil
+}
+
+func (fakeResult) RowsAffected() (int64, error) {
+	return 0, nil
+}
@@ -0,0 +1,40 @@
+package qrn
+
+import (
+	"testing"
+)
+
+func TestParseFakeDSN(t *testing.T) {
+	config, err := ParseFakeDSN("fake:latency=lognormal:2ms:0.5&error-rate=0.01&stall=uniform:1s:3s&stall-rate=0.001")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if config.Latency.String() != "lognormal:2ms:0.5" || config.Stall.String() != "uniform:1s:3s" {
+		t.Errorf("unexpected distributions: %s, %s", config.Latency, config.Stall)
+	}
+
+	if config.ErrorRate != 0.01 || config.StallRate != 0.001 {
+		t.Errorf("unexpected rates: %g, %g", config.ErrorRate, config.StallRate)
+	}
+}
+
+func TestParseFakeDSNError(t *testing.T) {
+	tests := []string{
+		"fake:latency=x",
+		"fake:error-rate=x",
+		"fake:error-rate=-0.1",
+		"fake:error-rate=1.5",
+		"fake:stall=1s&stall-rate=-1",
+		"fake:stall=1s&stall-rate=2",
+		"fake:stall-rate=0.1",
+		"fake:unknown=1",
+	}
+
+	for _, dsn := range tests {
+		if _, err := ParseFakeDSN(dsn); err == nil {
+			t.Errorf("ParseFakeDSN(%q): expected an error", dsn)
+		}
+	}
+}
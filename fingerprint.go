@@ -0,0 +1,166 @@
+package qrn
+
+import (
+	"strings"
+)
+
+// FingerprintCacheSize is the maximum number of queries whose fingerprints are cached by each agent.
+const FingerprintCacheSize = 10000
+
+// Fingerprint normalizes a query into its shape by replacing literals with '?'
+// and collapsing whitespace and comments, e.g.
+//
+//	SELECT * FROM t WHERE id IN (1, 2, 3) AND name = 'foo'
+//	=> select * from t where id in (...) and name = ?
+//
+// The syntax depends on the driver: in MySQL, '#' starts a comment and "..." is a string,
+// while in PostgreSQL and SQLite, '#' is an operator and "..." is a quoted identifier, which is kept as is.
+func Fingerprint(driver string, query string) string {
+	var b strings.Builder
+	b.Grow(len(query))
+	space := false
+	n := len(query)
+	mysqlSyntax := driver != "pgx" && driver != "sqlite"
+
+	for i := 0; i < n; i++ {
+		c := query[i]
+
+		switch {
+		case c == '"' && !mysqlSyntax:
+			start := i
+			i = skipQuoted(query, i, c, false)
+			writeSpace(&b, &space)
+
+			if i < n {
+				b.WriteString(query[start : i+1])
+			} else {
+				b.WriteString(query[start:])
+			}
+		case c == '\'' || c == '"':
+			i = skipQuoted(query, i, c, mysqlSyntax || isEscapeString(query, i))
+			writeSpace(&b, &space)
+			b.WriteByte('?')
+		case c == '-' && i+1 < n && query[i+1] == '-', c == '#' && mysqlSyntax:
+			for i < n && query[i] != '\n' {
+				i++
+			}
+
+			space = true
+			continue
+		case c == '/' && i+1 < n && query[i+1] == '*':
+			end := strings.Index(query[i+2:], "*/")
+
+			if end < 0 {
+				i = n
+			} else {
+				i += end + 3
+			}
+
+			space = true
+			continue
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			space = true
+			continue
+		case isDigit(c) && (space || !isIdentChar(prevByte(&b))):
+			for i+1 < n && (isIdentChar(query[i+1]) || query[i+1] == '.') {
+				i++
+			}
+
+			writeSpace(&b, &space)
+			b.WriteByte('?')
+			continue
+		default:
+			writeSpace(&b, &space)
+
+			if 'A' <= c && c <= 'Z' {
+				c += 'a' - 'A'
+			}
+
+			b.WriteByte(c)
+			continue
+		}
+
+		space = false
+	}
+
+	return collapseLists(b.String())
+}
+
+func writeSpace(b *strings.Builder, space *bool) {
+	if *space && b.Len() > 0 {
+		b.WriteByte(' ')
+	}
+
+	*space = false
+}
+
+// skipQuoted returns the position of the closing quote. A doubled quote is an escaped quote,
+// and so is a backslash if backslashEscapes is set.
+func skipQuoted(query string, i int, quote byte, backslashEscapes bool) int {
+	for i++; i < len(query); i++ {
+		if query[i] == '\\' && backslashEscapes {
+			i++
+		} else if query[i] == quote {
+			if i+1 < len(query) && query[i+1] == quote {
+				i++
+			} else {
+				break
+			}
+		}
+	}
+
+	return i
+}
+
+// isEscapeString reports whether the quote at i starts a PostgreSQL escape string (e.g. E'it\'s').
+func isEscapeString(query string, i int) bool {
+	return i > 0 && (query[i-1] == 'E' || query[i-1] == 'e') && (i < 2 || !isIdentChar(query[i-2]))
+}
+
+func prevByte(b *strings.Builder) byte {
+	s := b.String()
+
+	if len(s) == 0 {
+		return ' '
+	}
+
+	return s[len(s)-1]
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+func isIdentChar(c byte) bool {
+	return isDigit(c) || c == '_' || c == '$' || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')
+}
+
+// collapseLists replaces lists of placeholders such as "(?, ?, ?)" with "(...)".
+func collapseLists(s string) string {
+	if !strings.Contains(s, "(?") {
+		return s
+	}
+
+	var b strings.Builder
+	b.Grow(len(s))
+
+	for i := 0; i < len(s); i++ {
+		if s[i] == '(' {
+			j := i + 1
+
+			for j < len(s) && (s[j] == '?' || s[j] == ',' || s[j] == ' ') {
+				j++
+			}
+
+			if j < len(s) && s[j] == ')' && j > i+1 {
+				b.WriteString("(...)")
+				i = j
+				continue
+			}
+		}
+
+		b.WriteByte(s[i])
+	}
+
+	return b.String()
+}
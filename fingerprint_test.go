@@ -0,0 +1,51 @@
+package qrn
+
+import (
+	"testing"
+)
+
+func TestFingerprint(t *testing.T) {
+	tests := []struct {
+		driver   string
+		query    string
+		expected string
+	}{
+		{"mysql", "SELECT * FROM t WHERE id IN (1, 2, 3) AND name = 'foo'", "select * from t where id in (...) and name = ?"},
+		{"mysql", "select  *\n\tfrom t where id = 1", "select * from t where id = ?"},
+		{"mysql", "select * from t1 where c2 = 3.14", "select * from t1 where c2 = ?"},
+		{"mysql", "select 'it''s', 'a\\'b', \"c\"", "select ?, ?, ?"},
+		{"mysql", "select 1 -- comment\nfrom dual", "select ? from dual"},
+		{"mysql", "select 1 # comment\nfrom dual", "select ? from dual"},
+		{"mysql", "select /* hint */ 1 from t", "select ? from t"},
+		{"mysql", "select 1 /* unterminated", "select ?"},
+		{"mysql", "insert into t values (1, 'a'), (2, 'b')", "insert into t values (...), (...)"},
+		{"pgx", "SELECT \"Name\" FROM t WHERE id = $1", "select \"Name\" from t where id = $1"},
+		{"pgx", "select 5 # 3", "select ? # ?"},
+		{"pgx", "select 'C:\\' || x from t", "select ? || x from t"},
+		{"pgx", "select E'it\\'s' from t", "select e? from t"},
+		{"pgx", "select 1 -- comment\nfrom t", "select ? from t"},
+		{"sqlite", "select \"a\"\"b\" from t where c = 'x'", "select \"a\"\"b\" from t where c = ?"},
+		{"sqlite", "select \"unterminated", "select \"unterminated"},
+		{"fake", "select \"c\" # comment", "select ?"},
+	}
+
+	for _, tt := range tests {
+		if fp := Fingerprint(tt.driver, tt.query); fp != tt.expected {
+			t.Errorf("Fingerprint(%q, %q): expected %q, got %q", tt.driver, tt.query, tt.expected, fp)
+		}
+	}
+}
+
+func TestAgentFingerprintCache(t *testing.T) {
+	agent := &Agent{ConnInfo: &ConnInfo{Driver: "pgx"}}
+
+	for i := 0; i < 2; i++ {
+		if fp := agent.fingerprint("select \"A\" from t where id = 1"); fp != "select \"A\" from t where id = ?" {
+			t.Errorf("unexpected fingerprint: %s", fp)
+		}
+	}
+
+	if len(agent.fingerprints) != 1 {
+		t.Errorf("expected 1 cached fingerprint, got %d", len(agent.fingerprints))
+	}
+}
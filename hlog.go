@@ -0,0 +1,162 @@
+package qrn
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	hdrhistogram "github.com/HdrHistogram/hdrhistogram-go"
+)
+
+const DefaultHistogramLogInterval = 1 * time.Second
+const DefaultHistogramLogDigits = 3
+
+// HistogramLogMaxLatency is the highest latency recorded in the log. Longer latencies are recorded as this value.
+const HistogramLogMaxLatency = time.Hour
+
+// HistogramLog writes the latency histogram of each interval in the HdrHistogram interval log format (.hlog)
+// for HistogramLogAnalyzer and other HdrHistogram tools.
+// Latencies are recorded in nanoseconds, and the max of each interval is written in milliseconds.
+// Intervals are written with the same delay as TimeSeries.
+type HistogramLog struct {
+	sync.Mutex
+	Interval          time.Duration
+	SignificantDigits int
+	Out               io.Writer
+	recorder          *Recorder
+	histogram         *hdrhistogram.Histogram
+	pending           []DataPoint
+	responsePos       int
+	errorPos          int
+	start             time.Time
+	err               error
+}
+
+func NewHistogramLog(out io.Writer, interval time.Duration, digits int) *HistogramLog {
+	if interval <= 0 {
+		interval = DefaultHistogramLogInterval
+	}
+
+	return &HistogramLog{
+		Interval:          interval,
+		SignificantDigits: digits,
+		Out:               out,
+		histogram:         hdrhistogram.New(1, int64(HistogramLogMaxLatency), digits),
+	}
+}
+
+func (hl *HistogramLog) begin(recorder *Recorder) {
+	hl.recorder = recorder
+	hl.start = recorder.Started
+	started := float64(recorder.Started.UnixNano()) / float64(time.Second)
+
+	// timestamps of intervals are relative to the base time
+	hl.printf("#[Histogram log format version %s]\n", hdrhistogram.HISTOGRAM_LOG_FORMAT_VERSION)
+	hl.printf("#[StartTime: %.3f (seconds since epoch), %s]\n", started, recorder.Started.Format(time.RFC3339))
+	hl.printf("#[BaseTime: %.3f (seconds since epoch)]\n", started)
+	hl.printf("%s\n", `"StartTimestamp","Interval_Length","Interval_Max","Interval_Compressed_Histogram"`)
+}
+
+// keep keeps the first error, which is returned by Close.
+func (hl *HistogramLog) keep(err error) {
+	if hl.err == nil {
+		hl.err = err
+	}
+}
+
+func (hl *HistogramLog) printf(format string, a ...interface{}) {
+	_, err := fmt.Fprintf(hl.Out, format, a...)
+	hl.keep(err)
+}
+
+// Close returns the first error of encoding or writing the log. Out is not closed.
+func (hl *HistogramLog) Close() error {
+	hl.Lock()
+	defer hl.Unlock()
+	return hl.err
+}
+
+func (hl *HistogramLog) run(ctx context.Context) {
+	ticker := time.NewTicker(hl.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			hl.flush(time.Now().Add(-AgentInterruptPeriod))
+		}
+	}
+}
+
+// flush writes the intervals that end before the cutoff.
+func (hl *HistogramLog) flush(cutoff time.Time) {
+	hl.Lock()
+	defer hl.Unlock()
+
+	if hl.recorder == nil {
+		return
+	}
+
+	hl.pending, hl.responsePos, hl.errorPos = hl.recorder.pointsSince(hl.pending, hl.responsePos, hl.errorPos)
+	sort.Slice(hl.pending, func(i, j int) bool { return hl.pending[i].Time.Before(hl.pending[j].Time) })
+
+	for !hl.start.Add(hl.Interval).After(cutoff) {
+		end := hl.start.Add(hl.Interval)
+		hl.keep(hl.write(end))
+		hl.start = end
+	}
+}
+
+// finish writes the remaining intervals including the last partial one.
+func (hl *HistogramLog) finish(finished time.Time) {
+	hl.flush(finished)
+
+	hl.Lock()
+	defer hl.Unlock()
+
+	if hl.recorder != nil && finished.After(hl.start) {
+		hl.keep(hl.write(finished))
+		hl.start = finished
+	}
+}
+
+// write records the pending data points before the end of the interval and writes the histogram.
+// Timed-out queries are recorded only if ClampTimeouts is set, as in the latency metrics.
+func (hl *HistogramLog) write(end time.Time) error {
+	n := sort.Search(len(hl.pending), func(i int) bool { return !hl.pending[i].Time.Before(end) })
+	hl.histogram.Reset()
+
+	for _, v := range hl.pending[:n] {
+		if v.Error != "" && !(v.Error == ErrorClassTimeout && hl.recorder.ClampTimeouts) {
+			continue
+		}
+
+		rt := v.ResponseTime
+
+		if rt > HistogramLogMaxLatency {
+			rt = HistogramLogMaxLatency
+		}
+
+		hl.histogram.RecordValue(int64(rt))
+	}
+
+	hl.pending = hl.pending[n:]
+	encoded, err := hl.histogram.Encode(hdrhistogram.V2CompressedEncodingCookieBase)
+
+	if err != nil {
+		return fmt.Errorf("failed to encode histogram: %w", err)
+	}
+
+	_, err = fmt.Fprintf(hl.Out, "%.3f,%.3f,%.3f,%s\n",
+		hl.start.Sub(hl.recorder.Started).Seconds(),
+		end.Sub(hl.start).Seconds(),
+		float64(hl.histogram.Max())/float64(time.Millisecond),
+		encoded)
+
+	return err
+}
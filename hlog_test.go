@@ -0,0 +1,69 @@
+package qrn
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+type failingWriter struct {
+	n int
+}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if w.n <= 0 {
+		return 0, errors.New("disk full")
+	}
+
+	w.n--
+
+	return len(p), nil
+}
+
+func writeHistogramLog(out *HistogramLog) {
+	started := time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC)
+	recorder := &Recorder{Started: started, ResponseTimes: []DataPoint{}, ErrorPoints: []DataPoint{}}
+	out.begin(recorder)
+
+	for i := 0; i < 30; i++ {
+		recorder.AppendResponseTimes([]DataPoint{{Time: started.Add(time.Duration(i) * 100 * time.Millisecond), ResponseTime: time.Millisecond}})
+	}
+
+	out.finish(started.Add(2500 * time.Millisecond))
+}
+
+func TestHistogramLog(t *testing.T) {
+	var buf bytes.Buffer
+	hl := NewHistogramLog(&buf, time.Second, 3)
+	writeHistogramLog(hl)
+
+	if err := hl.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+
+	if len(lines) != 7 {
+		t.Fatalf("expected 4 header lines and 3 intervals, got %q", lines)
+	}
+
+	for i, prefix := range []string{"0.000,1.000,1.000,", "1.000,1.000,1.000,", "2.000,0.500,1.000,"} {
+		if !strings.HasPrefix(lines[4+i], prefix) {
+			t.Errorf("expected prefix %q, got %q", prefix, lines[4+i])
+		}
+	}
+}
+
+func TestHistogramLogWriteError(t *testing.T) {
+	// fail at the header and at the first interval
+	for _, n := range []int{0, 4} {
+		hl := NewHistogramLog(&failingWriter{n: n}, time.Second, 3)
+		writeHistogramLog(hl)
+
+		if err := hl.Close(); err == nil || err.Error() != "disk full" {
+			t.Errorf("expected disk full after %d writes, got %v", n, err)
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package qrn
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// MaxMetricsFingerprints is the maximum number of fingerprint labels. Other queries are labeled "other".
+const MaxMetricsFingerprints = 100
+
+// MetricsBuckets are the upper bounds (sec) of the latency histogram.
+var MetricsBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Observer receives the data points sent by agents.
+// Observe is called from the recorder goroutine, so it must not block.
+type Observer interface {
+	Observe([]DataPoint)
+}
+
+type metricsKey struct {
+	target      int
+	route       string
+	fingerprint string
+}
+
+type errorsKey struct {
+	target int
+	route  string
+	class  string
+}
+
+type latencyHistogram struct {
+	buckets []uint64
+	count   uint64
+	sum     float64
+}
+
+// MetricsServer exposes live metrics of a task in the Prometheus text format.
+//
+//	GET /metrics
+type MetricsServer struct {
+	sync.Mutex
+	Task         *Task
+	server       *http.Server
+	histograms   map[metricsKey]*latencyHistogram
+	errors       map[errorsKey]uint64
+	fingerprints map[string]bool
+}
+
+func NewMetricsServer(task *Task) *MetricsServer {
+	ms := &MetricsServer{
+		Task:         task,
+		histograms:   map[metricsKey]*latencyHistogram{},
+		errors:       map[errorsKey]uint64{},
+		fingerprints: map[string]bool{},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", ms.handleMetrics)
+	ms.server = &http.Server{Handler: mux}
+	task.AddObserver(ms)
+
+	return ms
+}
+
+func (ms *MetricsServer) Start(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+
+	if err != nil {
+		return err
+	}
+
+	go ms.server.Serve(listener)
+
+	return nil
+}
+
+func (ms *MetricsServer) Close() error {
+	return ms.server.Close()
+}
+
+func (ms *MetricsServer) Observe(responseTimes []DataPoint) {
+	ms.Lock()
+	defer ms.Unlock()
+
+	for _, v := range responseTimes {
+		if v.Error != "" {
+			ms.errors[errorsKey{target: v.Target, route: v.Route, class: v.Error}]++
+			continue
+		}
+
+		fp := v.Fingerprint
+
+		if !ms.fingerprints[fp] {
+			if len(ms.fingerprints) < MaxMetricsFingerprints {
+				ms.fingerprints[fp] = true
+			} else {
+				fp = "other"
+			}
+		}
+
+		key := metricsKey{target: v.Target, route: v.Route, fingerprint: fp}
+		h, ok := ms.histograms[key]
+
+		if !ok {
+			h = &latencyHistogram{buckets: make([]uint64, len(MetricsBuckets))}
+			ms.histograms[key] = h
+		}
+
+		sec := v.ResponseTime.Seconds()
+
+		for i, le := range MetricsBuckets {
+			if sec <= le {
+				h.buckets[i]++
+			}
+		}
+
+		h.count++
+		h.sum += sec
+	}
+}
+
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func (ms *MetricsServer) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	// rendered before writing, so that a slow scraper does not block Observe
+	w.Write(ms.render())
+}
+
+func (ms *MetricsServer) render() []byte {
+	var out bytes.Buffer
+	task := ms.Task
+	var inFlight int64
+
+	if recorder := task.Recorder(); recorder != nil {
+		inFlight = atomic.LoadInt64(&recorder.InFlight)
+	}
+
+	gauge := func(name string, help string, v float64) {
+		fmt.Fprintf(&out, "# HELP %s %s\n# TYPE %s gauge\n%s %s\n", name, help, name, name, formatFloat(v))
+	}
+
+	gauge("qrn_agents", "Number of running agents.", float64(task.Running()))
+	gauge("qrn_in_flight_queries", "Number of queries being executed.", float64(inFlight))
+	gauge("qrn_target_rate", "Rate limit for each agent (qps). Zero is unlimited.", float64(task.Rate()))
+	ms.writeSeries(&out)
+
+	return out.Bytes()
+}
+
+func (ms *MetricsServer) writeSeries(out io.Writer) {
+	ms.Lock()
+	defer ms.Unlock()
+
+	keys := make([]metricsKey, 0, len(ms.histograms))
+
+	for k := range ms.histograms {
+		keys = append(keys, k)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+
+		if a.target != b.target {
+			return a.target < b.target
+		} else if a.route != b.route {
+			return a.route < b.route
+		}
+
+		return a.fingerprint < b.fingerprint
+	})
+
+	fmt.Fprintf(out, "# HELP qrn_queries_total Number of successful queries.\n# TYPE qrn_queries_total counter\n")
+
+	for _, k := range keys {
+		fmt.Fprintf(out, "qrn_queries_total{%s} %d\n", ms.labels(k.target, k.route, "fingerprint", k.fingerprint), ms.histograms[k].count)
+	}
+
+	fmt.Fprintf(out, "# HELP qrn_query_duration_seconds Response time of successful queries.\n# TYPE qrn_query_duration_seconds histogram\n")
+
+	for _, k := range keys {
+		h := ms.histograms[k]
+		labels := ms.labels(k.target, k.route, "fingerprint", k.fingerprint)
+
+		for i, le := range MetricsBuckets {
+			fmt.Fprintf(out, "qrn_query_duration_seconds_bucket{%s,le=\"%s\"} %d\n", labels, formatFloat(le), h.buckets[i])
+		}
+
+		fmt.Fprintf(out, "qrn_query_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", labels, h.count)
+		fmt.Fprintf(out, "qrn_query_duration_seconds_sum{%s} %s\n", labels, formatFloat(h.sum))
+		fmt.Fprintf(out, "qrn_query_duration_seconds_count{%s} %d\n", labels, h.count)
+	}
+
+	errorKeys := make([]errorsKey, 0, len(ms.errors))
+
+	for k := range ms.errors {
+		errorKeys = append(errorKeys, k)
+	}
+
+	sort.Slice(errorKeys, func(i, j int) bool {
+		a, b := errorKeys[i], errorKeys[j]
+
+		if a.target != b.target {
+			return a.target < b.target
+		} else if a.route != b.route {
+			return a.route < b.route
+		}
+
+		return a.class < b.class
+	})
+
+	fmt.Fprintf(out, "# HELP qrn_errors_total Number of failed queries by error class.\n# TYPE qrn_errors_total counter\n")
+
+	for _, k := range errorKeys {
+		fmt.Fprintf(out, "qrn_errors_total{%s} %d\n", ms.labels(k.target, k.route, "class", k.class), ms.errors[k])
+	}
+}
+
+// labels returns the labels of a series: the agent group (index of the target), the host of the target, the route and the given label.
+func (ms *MetricsServer) labels(target int, route string, name string, value string) string {
+	host := ""
+
+	if target < len(ms.Task.targets) {
+		host = ms.Task.targets[target].ConnInfo.Host
+	}
+
+	return fmt.Sprintf("group=\"%d\",target=\"%s\",route=\"%s\",%s=\"%s\"", target, escapeLabel(host), escapeLabel(route), name, escapeLabel(value))
+}
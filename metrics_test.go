@@ -0,0 +1,74 @@
+package qrn
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricsExposition(t *testing.T) {
+	task := NewTask(&TaskOptions{DSNs: Strings{"root@tcp(db1:3306)/", "root@tcp(db2:3306)/"}, Rate: 10})
+	ms := NewMetricsServer(task)
+
+	ms.Observe([]DataPoint{
+		{ResponseTime: 2 * time.Millisecond, Fingerprint: "select ?"},
+		{ResponseTime: 20 * time.Millisecond, Fingerprint: "select ?"},
+		{ResponseTime: 3 * time.Second, Fingerprint: "update t set \"a\" = ?", Target: 1, Route: RoutePrimary},
+		{ResponseTime: time.Millisecond, Fingerprint: "select ?", Error: "mysql:1062"},
+		{ResponseTime: time.Second, Fingerprint: "select ?", Error: ErrorClassTimeout, Target: 1, Route: RouteReplica},
+	})
+
+	w := httptest.NewRecorder()
+	ms.handleMetrics(w, httptest.NewRequest("GET", "/metrics", nil))
+
+	if ct := w.Header().Get("Content-Type"); ct != "text/plain; version=0.0.4" {
+		t.Errorf("unexpected content type: %s", ct)
+	}
+
+	body := w.Body.String()
+
+	for _, expected := range []string{
+		"# TYPE qrn_agents gauge\nqrn_agents 0\n",
+		"qrn_in_flight_queries 0\n",
+		"qrn_target_rate 10\n",
+		"# TYPE qrn_queries_total counter\n" +
+			`qrn_queries_total{group="0",target="db1:3306",route="",fingerprint="select ?"} 2` + "\n" +
+			`qrn_queries_total{group="1",target="db2:3306",route="primary",fingerprint="update t set \"a\" = ?"} 1` + "\n",
+		`qrn_query_duration_seconds_bucket{group="0",target="db1:3306",route="",fingerprint="select ?",le="0.001"} 0` + "\n" +
+			`qrn_query_duration_seconds_bucket{group="0",target="db1:3306",route="",fingerprint="select ?",le="0.0025"} 1` + "\n",
+		`qrn_query_duration_seconds_bucket{group="0",target="db1:3306",route="",fingerprint="select ?",le="0.025"} 2` + "\n",
+		`qrn_query_duration_seconds_bucket{group="0",target="db1:3306",route="",fingerprint="select ?",le="+Inf"} 2` + "\n" +
+			`qrn_query_duration_seconds_sum{group="0",target="db1:3306",route="",fingerprint="select ?"} 0.022` + "\n" +
+			`qrn_query_duration_seconds_count{group="0",target="db1:3306",route="",fingerprint="select ?"} 2` + "\n",
+		`qrn_query_duration_seconds_bucket{group="1",target="db2:3306",route="primary",fingerprint="update t set \"a\" = ?",le="2.5"} 0` + "\n",
+		"# TYPE qrn_errors_total counter\n" +
+			`qrn_errors_total{group="0",target="db1:3306",route="",class="mysql:1062"} 1` + "\n" +
+			`qrn_errors_total{group="1",target="db2:3306",route="replica",class="timeout"} 1` + "\n",
+	} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("expected %q in:\n%s", expected, body)
+		}
+	}
+}
+
+func TestMetricsFingerprintLimit(t *testing.T) {
+	task := NewTask(&TaskOptions{DSNs: Strings{"root@tcp(db1:3306)/"}})
+	ms := NewMetricsServer(task)
+	points := []DataPoint{}
+
+	for i := 0; i < MaxMetricsFingerprints+10; i++ {
+		points = append(points, DataPoint{ResponseTime: time.Millisecond, Fingerprint: strings.Repeat("x", i+1)})
+	}
+
+	ms.Observe(points)
+	body := string(ms.render())
+
+	if n := strings.Count(body, "qrn_queries_total{"); n != MaxMetricsFingerprints+1 {
+		t.Errorf("expected %d series, got %d", MaxMetricsFingerprints+1, n)
+	}
+
+	if !strings.Contains(body, `fingerprint="other"} 10`+"\n") {
+		t.Errorf("expected 10 queries labeled other in:\n%s", body)
+	}
+}
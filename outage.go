@@ -0,0 +1,92 @@
+package qrn
+
+import (
+	"sort"
+	"time"
+)
+
+// Outage is a period when an agent could not execute queries.
+type Outage struct {
+	Agent int
+	// Started is the start time of the first failed query
+	Started time.Time
+	// Recovered is the end time of the first successful query after the outage.
+	// It is zero if the agent did not recover until the end of the run.
+	Recovered time.Time
+	Duration  time.Duration
+	Errors    int
+}
+
+// DowntimeWindow is a period when at least one agent could not execute queries.
+type DowntimeWindow struct {
+	Started time.Time
+	// FirstRecovered is the end time of the first successful query of any agent after the window started
+	FirstRecovered time.Time
+	Finished       time.Time
+	Duration       time.Duration
+	TimeToRecover  time.Duration
+	Errors         int
+}
+
+func (outage *Outage) recover(tm time.Time) {
+	outage.Recovered = tm
+	outage.Duration = tm.Sub(outage.Started)
+}
+
+func (outage *Outage) finished(end time.Time) time.Time {
+	if outage.Recovered.IsZero() {
+		return end
+	}
+
+	return outage.Recovered
+}
+
+// downtimeWindows merges the overlapping outages of all agents.
+func downtimeWindows(outages []*Outage, end time.Time) []DowntimeWindow {
+	sorted := make([]*Outage, len(outages))
+	copy(sorted, outages)
+
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Started.Before(sorted[j].Started)
+	})
+
+	windows := []DowntimeWindow{}
+
+	for _, v := range sorted {
+		finished := v.finished(end)
+		n := len(windows)
+
+		if n > 0 && !v.Started.After(windows[n-1].Finished) {
+			w := &windows[n-1]
+			w.Errors += v.Errors
+
+			if finished.After(w.Finished) {
+				w.Finished = finished
+			}
+
+			if !v.Recovered.IsZero() && (w.FirstRecovered.IsZero() || v.Recovered.Before(w.FirstRecovered)) {
+				w.FirstRecovered = v.Recovered
+			}
+
+			continue
+		}
+
+		windows = append(windows, DowntimeWindow{
+			Started:        v.Started,
+			FirstRecovered: v.Recovered,
+			Finished:       finished,
+			Errors:         v.Errors,
+		})
+	}
+
+	for i := range windows {
+		w := &windows[i]
+		w.Duration = w.Finished.Sub(w.Started)
+
+		if !w.FirstRecovered.IsZero() {
+			w.TimeToRecover = w.FirstRecovered.Sub(w.Started)
+		}
+	}
+
+	return windows
+}
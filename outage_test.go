@@ -0,0 +1,121 @@
+package qrn
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const outageDriverName = "fake-outage"
+
+// outageDriver is the fake driver whose connections and queries fail while the database is down.
+type outageDriver struct {
+	down int32
+}
+
+type outageConn struct {
+	*fakeConn
+	driver *outageDriver
+}
+
+var testOutageDriver = &outageDriver{}
+
+func init() {
+	sql.Register(outageDriverName, testOutageDriver)
+}
+
+func (d *outageDriver) isDown() bool {
+	return atomic.LoadInt32(&d.down) == 1
+}
+
+func (d *outageDriver) setDown(down bool) {
+	var v int32
+
+	if down {
+		v = 1
+	}
+
+	atomic.StoreInt32(&d.down, v)
+}
+
+func (d *outageDriver) Open(dsn string) (driver.Conn, error) {
+	if d.isDown() {
+		return nil, driver.ErrBadConn
+	}
+
+	return &outageConn{fakeConn: &fakeConn{config: &FakeConfig{}}, driver: d}, nil
+}
+
+func (conn *outageConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if conn.driver.isDown() {
+		return nil, driver.ErrBadConn
+	}
+
+	return conn.fakeConn.ExecContext(ctx, query, args)
+}
+
+func (conn *outageConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if conn.driver.isDown() {
+		return nil, driver.ErrBadConn
+	}
+
+	return conn.fakeConn.QueryContext(ctx, query, args)
+}
+
+func TestOutageAndDowntime(t *testing.T) {
+	defer testOutageDriver.setDown(false)
+
+	options := &TaskOptions{
+		Driver:    outageDriverName,
+		DSNs:      Strings{"outage"},
+		Files:     Strings{testData(t, "select 1")},
+		Loop:      true,
+		NAgents:   2,
+		Reconnect: true,
+	}
+
+	go func() {
+		time.Sleep(300 * time.Millisecond)
+		testOutageDriver.setDown(true)
+		time.Sleep(300 * time.Millisecond)
+		testOutageDriver.setDown(false)
+	}()
+
+	_, report, err := runTask(t, options, 1500*time.Millisecond, 10*time.Millisecond)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(report.Outages) != 2 {
+		t.Fatalf("expected an outage for each agent, got %d", len(report.Outages))
+	}
+
+	for _, v := range report.Outages {
+		if v.Recovered.IsZero() {
+			t.Errorf("expected agent %d to recover", v.Agent)
+		}
+
+		if v.Errors == 0 {
+			t.Errorf("expected errors in the outage of agent %d", v.Agent)
+		}
+
+		if v.Duration < 250*time.Millisecond || v.Duration > time.Second {
+			t.Errorf("expected the outage of agent %d to last about 300ms, got %s", v.Agent, v.Duration)
+		}
+	}
+
+	// the outages of the agents overlap
+	if len(report.Downtimes) != 1 {
+		t.Fatalf("expected a downtime window, got %d", len(report.Downtimes))
+	}
+
+	w := report.Downtimes[0]
+
+	if report.Downtime != w.Duration || w.TimeToRecover <= 0 || w.TimeToRecover > w.Duration {
+		t.Errorf("unexpected downtime: %s, window: %+v", report.Downtime, w)
+	}
+}
@@ -0,0 +1,312 @@
+package qrn
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	PushFormatStatsD    = "statsd"
+	PushFormatDogStatsD = "dogstatsd"
+	PushFormatInflux    = "influx"
+)
+
+const DefaultPushInterval = 10 * time.Second
+
+// MaxPushPacketSize is the maximum UDP payload, which fits in the common MTU.
+const MaxPushPacketSize = 1432
+
+// MaxStatsDTimers is the maximum number of timer samples sent in an interval.
+// Timers beyond it are sampled with the StatsD sample rate.
+const MaxStatsDTimers = 200
+
+// Pusher sends the aggregates of each interval over UDP as StatsD, DogStatsD or InfluxDB line protocol.
+type Pusher struct {
+	sync.Mutex
+	Task      *Task
+	Addr      string
+	Format    string
+	Prefix    string
+	Tags      Strings
+	Interval  time.Duration
+	conn      net.Conn
+	latencies []time.Duration
+	errors    map[string]int
+	last      time.Time
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+func NewPusher(task *Task, addr string, format string, prefix string, tags Strings, interval time.Duration) *Pusher {
+	if interval <= 0 {
+		interval = DefaultPushInterval
+	}
+
+	pusher := &Pusher{
+		Task:     task,
+		Addr:     addr,
+		Format:   format,
+		Prefix:   prefix,
+		Tags:     tags,
+		Interval: interval,
+		errors:   map[string]int{},
+	}
+
+	task.AddObserver(pusher)
+
+	return pusher
+}
+
+func (pusher *Pusher) Start() error {
+	conn, err := net.Dial("udp", pusher.Addr)
+
+	if err != nil {
+		return err
+	}
+
+	pusher.conn = conn
+	pusher.last = time.Now()
+	pusher.stop = make(chan struct{})
+	pusher.done = make(chan struct{})
+
+	go func() {
+		ticker := time.NewTicker(pusher.Interval)
+		defer ticker.Stop()
+		defer close(pusher.done)
+
+		for {
+			select {
+			case <-pusher.stop:
+				return
+			case <-ticker.C:
+				pusher.flush()
+			}
+		}
+	}()
+
+	return nil
+}
+
+// Close sends the last aggregates and closes the connection.
+func (pusher *Pusher) Close() error {
+	close(pusher.stop)
+	<-pusher.done
+	pusher.flush()
+
+	return pusher.conn.Close()
+}
+
+func (pusher *Pusher) Observe(responseTimes []DataPoint) {
+	pusher.Lock()
+	defer pusher.Unlock()
+
+	for _, v := range responseTimes {
+		if v.Route == RouteShadow {
+			continue
+		}
+
+		if v.Error != "" {
+			pusher.errors[v.Error]++
+		} else {
+			pusher.latencies = append(pusher.latencies, v.ResponseTime)
+		}
+	}
+}
+
+func (pusher *Pusher) flush() {
+	pusher.Lock()
+	latencies := pusher.latencies
+	errors := pusher.errors
+	pusher.latencies = nil
+	pusher.errors = map[string]int{}
+	now := time.Now()
+	elapsed := now.Sub(pusher.last)
+	pusher.last = now
+	pusher.Unlock()
+
+	var inFlight int64
+
+	if recorder := pusher.Task.Recorder(); recorder != nil {
+		inFlight = atomic.LoadInt64(&recorder.InFlight)
+	}
+
+	var lines []string
+
+	if pusher.Format == PushFormatInflux {
+		lines = pusher.influxLines(latencies, errors, inFlight, elapsed)
+	} else {
+		lines = pusher.statsdLines(latencies, errors, inFlight)
+	}
+
+	pusher.send(lines)
+}
+
+// send writes the lines in as few packets as possible. Errors are ignored like other UDP metrics clients.
+func (pusher *Pusher) send(lines []string) {
+	var packet strings.Builder
+
+	for _, line := range lines {
+		if packet.Len() > 0 && packet.Len()+1+len(line) > MaxPushPacketSize {
+			pusher.conn.Write([]byte(packet.String()))
+			packet.Reset()
+		}
+
+		if packet.Len() > 0 {
+			packet.WriteByte('\n')
+		}
+
+		packet.WriteString(line)
+	}
+
+	if packet.Len() > 0 {
+		pusher.conn.Write([]byte(packet.String()))
+	}
+}
+
+func (pusher *Pusher) name(metric string) string {
+	if pusher.Prefix == "" {
+		return metric
+	}
+
+	return pusher.Prefix + "." + metric
+}
+
+// statsdTags returns the tags in the DogStatsD format (e.g. "|#env:test,class:timeout").
+// Plain StatsD does not support tags, so it returns an empty string.
+func (pusher *Pusher) statsdTags(extra ...string) string {
+	if pusher.Format != PushFormatDogStatsD {
+		return ""
+	}
+
+	tags := []string{}
+
+	for _, list := range [][]string{pusher.Tags, extra} {
+		for _, t := range list {
+			tags = append(tags, strings.Replace(t, "=", ":", 1))
+		}
+	}
+
+	if len(tags) == 0 {
+		return ""
+	}
+
+	return "|#" + strings.Join(tags, ",")
+}
+
+func (pusher *Pusher) statsdLines(latencies []time.Duration, errors map[string]int, inFlight int64) []string {
+	tags := pusher.statsdTags()
+
+	lines := []string{
+		fmt.Sprintf("%s:%d|c%s", pusher.name("queries"), len(latencies), tags),
+		fmt.Sprintf("%s:%d|g%s", pusher.name("agents"), pusher.Task.Running(), tags),
+		fmt.Sprintf("%s:%d|g%s", pusher.name("in_flight"), inFlight, tags),
+		fmt.Sprintf("%s:%d|g%s", pusher.name("rate"), pusher.Task.Rate(), tags),
+	}
+
+	for _, class := range sortedKeys(errors) {
+		if pusher.Format == PushFormatDogStatsD {
+			lines = append(lines, fmt.Sprintf("%s:%d|c%s", pusher.name("errors"), errors[class], pusher.statsdTags("class="+class)))
+		} else {
+			// the error class is a part of the name in plain StatsD, where ':' separates the value
+			lines = append(lines, fmt.Sprintf("%s:%d|c", pusher.name("errors."+strings.ReplaceAll(class, ":", "_")), errors[class]))
+		}
+	}
+
+	sampleRate := 1.0
+
+	if len(latencies) > MaxStatsDTimers {
+		sampleRate = float64(MaxStatsDTimers) / float64(len(latencies))
+	}
+
+	for _, v := range latencies {
+		if sampleRate < 1 {
+			if rand.Float64() >= sampleRate {
+				continue
+			}
+
+			lines = append(lines, fmt.Sprintf("%s:%.3f|ms|@%.4g%s", pusher.name("latency"), float64(v)/float64(time.Millisecond), sampleRate, tags))
+		} else {
+			lines = append(lines, fmt.Sprintf("%s:%.3f|ms%s", pusher.name("latency"), float64(v)/float64(time.Millisecond), tags))
+		}
+	}
+
+	return lines
+}
+
+// escapeInflux escapes commas, equal signs and spaces in measurements and tags.
+func escapeInflux(s string) string {
+	return strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `).Replace(s)
+}
+
+func (pusher *Pusher) influxLines(latencies []time.Duration, errors map[string]int, inFlight int64, elapsed time.Duration) []string {
+	measurement := pusher.Prefix
+
+	if measurement == "" {
+		measurement = "qrn"
+	}
+
+	measurement = escapeInflux(measurement)
+	tags := ""
+
+	for _, t := range pusher.Tags {
+		kv := strings.SplitN(t, "=", 2)
+
+		if len(kv) == 2 {
+			tags += "," + escapeInflux(kv[0]) + "=" + escapeInflux(kv[1])
+		}
+	}
+
+	ts := time.Now().UnixNano()
+	nErrors := 0
+
+	for _, n := range errors {
+		nErrors += n
+	}
+
+	fields := fmt.Sprintf("queries=%di,errors=%di,agents=%di,in_flight=%di,rate=%di,qps=%g",
+		len(latencies), nErrors, pusher.Task.Running(), inFlight, pusher.Task.Rate(),
+		float64(len(latencies))*float64(time.Second)/float64(elapsed))
+
+	if len(latencies) > 0 {
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+		var sum time.Duration
+
+		for _, v := range latencies {
+			sum += v
+		}
+
+		percentile := func(p float64) time.Duration {
+			return latencies[int(float64(len(latencies)-1)*p)]
+		}
+
+		// latencies in nanoseconds
+		fields += fmt.Sprintf(",p50=%di,p95=%di,p99=%di,max=%di,avg=%di",
+			percentile(0.5), percentile(0.95), percentile(0.99), latencies[len(latencies)-1], sum/time.Duration(len(latencies)))
+	}
+
+	lines := []string{fmt.Sprintf("%s%s %s %d", measurement, tags, fields, ts)}
+
+	for _, class := range sortedKeys(errors) {
+		lines = append(lines, fmt.Sprintf("%s_errors%s,class=%s count=%di %d", measurement, tags, escapeInflux(class), errors[class], ts))
+	}
+
+	return lines
+}
+
+func sortedKeys(m map[string]int) []string {
+	keys := make([]string, 0, len(m))
+
+	for k := range m {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
@@ -0,0 +1,233 @@
+package qrn
+
+import (
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// pushLines pushes the points and returns the lines received by a UDP server.
+func pushLines(t *testing.T, format string, tags Strings, points []DataPoint) []string {
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer server.Close()
+	task := NewTask(&TaskOptions{Rate: 50})
+	pusher := NewPusher(task, server.LocalAddr().String(), format, "qrn", tags, time.Hour)
+	err = pusher.Start()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pusher.Observe(points)
+	err = pusher.Close()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := []string{}
+	buf := make([]byte, 65536)
+
+	for {
+		server.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		n, _, err := server.ReadFrom(buf)
+
+		if err != nil {
+			break
+		}
+
+		if n > MaxPushPacketSize {
+			t.Errorf("packet too large: %d", n)
+		}
+
+		lines = append(lines, strings.Split(string(buf[:n]), "\n")...)
+	}
+
+	return lines
+}
+
+func TestPusher(t *testing.T) {
+	points := []DataPoint{
+		{ResponseTime: 1500 * time.Microsecond},
+		{ResponseTime: 2 * time.Millisecond},
+		{ResponseTime: 3 * time.Millisecond, Route: RouteShadow},
+		{Error: ErrorClassTimeout},
+		{Error: ErrorClassTimeout},
+		{Error: ErrorClassOther},
+		{Error: "mysql:1062"},
+	}
+
+	tests := []struct {
+		format   string
+		tags     Strings
+		expected []string
+	}{
+		{
+			PushFormatStatsD,
+			nil,
+			[]string{
+				"qrn.queries:2|c",
+				"qrn.agents:0|g",
+				"qrn.in_flight:0|g",
+				"qrn.rate:50|g",
+				"qrn.errors.mysql_1062:1|c",
+				"qrn.errors.other:1|c",
+				"qrn.errors.timeout:2|c",
+				"qrn.latency:1.500|ms",
+				"qrn.latency:2.000|ms",
+			},
+		},
+		{
+			PushFormatDogStatsD,
+			Strings{"env=test"},
+			[]string{
+				"qrn.queries:2|c|#env:test",
+				"qrn.agents:0|g|#env:test",
+				"qrn.in_flight:0|g|#env:test",
+				"qrn.rate:50|g|#env:test",
+				"qrn.errors:1|c|#env:test,class:mysql:1062",
+				"qrn.errors:1|c|#env:test,class:other",
+				"qrn.errors:2|c|#env:test,class:timeout",
+				"qrn.latency:1.500|ms|#env:test",
+				"qrn.latency:2.000|ms|#env:test",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			lines := pushLines(t, tt.format, tt.tags, points)
+
+			if !reflect.DeepEqual(lines, tt.expected) {
+				t.Errorf("expected %q, got %q", tt.expected, lines)
+			}
+		})
+	}
+}
+
+func TestPusherInflux(t *testing.T) {
+	points := []DataPoint{
+		{ResponseTime: time.Millisecond},
+		{ResponseTime: 3 * time.Millisecond},
+		{Error: ErrorClassTimeout},
+	}
+
+	lines := pushLines(t, PushFormatInflux, Strings{"env=test a"}, points)
+
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines, got %q", lines)
+	}
+
+	prefix := `qrn,env=test\ a queries=2i,errors=1i,agents=0i,in_flight=0i,rate=50i,qps=`
+
+	if !strings.HasPrefix(lines[0], prefix) {
+		t.Errorf("expected prefix %q, got %q", prefix, lines[0])
+	}
+
+	fields := `,p50=1000000i,p95=1000000i,p99=1000000i,max=3000000i,avg=2000000i `
+
+	if !strings.Contains(lines[0], fields) {
+		t.Errorf("expected %q in %q", fields, lines[0])
+	}
+
+	prefix = `qrn_errors,env=test\ a,class=timeout count=1i `
+
+	if !strings.HasPrefix(lines[1], prefix) {
+		t.Errorf("expected prefix %q, got %q", prefix, lines[1])
+	}
+}
+
+func TestPusherSplitsPackets(t *testing.T) {
+	points := make([]DataPoint, MaxStatsDTimers)
+
+	for i := range points {
+		points[i] = DataPoint{ResponseTime: time.Duration(i+1) * time.Millisecond}
+	}
+
+	// the lines do not fit in a packet
+	lines := pushLines(t, PushFormatStatsD, nil, points)
+	latencies := 0
+
+	for _, v := range lines {
+		if strings.HasPrefix(v, "qrn.latency:") {
+			latencies++
+
+			if strings.Contains(v, "|@") {
+				t.Errorf("expected no sampling: %q", v)
+			}
+		}
+	}
+
+	if latencies != len(points) {
+		t.Errorf("expected %d latency lines, got %d", len(points), latencies)
+	}
+}
+
+func TestPusherSamplesTimers(t *testing.T) {
+	points := make([]DataPoint, MaxStatsDTimers*10)
+
+	for i := range points {
+		points[i] = DataPoint{ResponseTime: time.Millisecond}
+	}
+
+	lines := pushLines(t, PushFormatStatsD, nil, points)
+	latencies := 0
+
+	if lines[0] != "qrn.queries:2000|c" {
+		t.Errorf("expected all queries to be counted, got %q", lines[0])
+	}
+
+	for _, v := range lines {
+		if strings.HasPrefix(v, "qrn.latency:") {
+			latencies++
+
+			if v != "qrn.latency:1.000|ms|@0.1" {
+				t.Errorf("expected a sampled timer, got %q", v)
+			}
+		}
+	}
+
+	// about MaxStatsDTimers timers are sent
+	if latencies < MaxStatsDTimers/2 || latencies > MaxStatsDTimers*2 {
+		t.Errorf("expected about %d latency lines, got %d", MaxStatsDTimers, latencies)
+	}
+}
+
+func TestPusherInterval(t *testing.T) {
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer server.Close()
+	pusher := NewPusher(NewTask(&TaskOptions{}), server.LocalAddr().String(), PushFormatStatsD, "qrn", nil, 50*time.Millisecond)
+	err = pusher.Start()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer pusher.Close()
+	pusher.Observe([]DataPoint{{ResponseTime: time.Millisecond}})
+
+	// the aggregates are sent without waiting for Close
+	buf := make([]byte, 65536)
+	server.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := server.ReadFrom(buf)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if packet := string(buf[:n]); !strings.HasPrefix(packet, "qrn.queries:1|c\n") {
+		t.Errorf("unexpected packet: %q", packet)
+	}
+}
@@ -9,66 +9,193 @@ import (
 	"github.com/winebarrel/tachymeter"
 )
 
+// MaxReportFingerprints is the maximum number of query shapes in the report.
+const MaxReportFingerprints = 100
+
 type Recorder struct {
 	sync.Mutex
-	Files         []string
-	PreQueris     []string
-	Channel       chan []DataPoint
-	ResponseTimes []DataPoint
+	Files          []string
+	PreQueris      []string
+	Channel        chan []DataPoint
+	ResponseTimes  []DataPoint
+	DSN            string
+	Targets        []*Target
+	Replicas       []string
+	Compare        bool
+	Shadow         string
+	ShadowPoints   []DataPoint
+	TimeSeries     *TimeSeries
+	HistogramLog   *HistogramLog
+	Observers      []Observer
+	InFlight       int64
+	Started        time.Time
+	Finished       time.Time
+	Metrics        *tachymeter.Metrics
+	NAgents        int
+	Rate           int
+	LoopCount      int64
+	HBins          int
+	HInterval      time.Duration
+	QPSHistory     []float64
+	Token          string
+	Concurrency    []ConcurrencyPoint
+	Annotations    []Annotation
+	ErrorPoints    []DataPoint
+	ErrorSamples   map[string]string
+	Outages        []*Outage
+	ClampTimeouts  bool
+	ConnectTimes   []time.Duration
+	ConnectMetrics *tachymeter.Metrics
+	targetLatency  []latencySum
+	Assertions     Assertions
+	Aborted        bool
+	Interrupted    bool
+	done           chan struct{}
+}
+
+type RecordReport struct {
 	DSN           string
+	Files         []string
+	PreQueries    []string
 	Started       time.Time
 	Finished      time.Time
-	Metrics       *tachymeter.Metrics
+	Elapsed       time.Duration
+	Queries       int
 	NAgents       int
 	Rate          int
+	QPS           float64
+	MaxQPS        float64
+	MinQPS        float64
+	MedianQPS     float64
+	ExpectedQPS   int
 	LoopCount     int64
-	HBins         int
-	HInterval     time.Duration
-	QPSHistory    []float64
+	Response      *tachymeter.Metrics
+	Connects      int
+	Connect       *tachymeter.Metrics
 	Token         string
+	GOMAXPROCS    int
+	MaxAgents     int
+	Concurrency   []ConcurrencyPoint
+	Annotations   []Annotation
+	Errors        int
+	ErrorRate     float64
+	Timeouts      int
+	TimeoutRate   float64
+	ErrorsByClass map[string]*ErrorStat
+	ErrorsByQuery map[string]int
+	ErrorTimeline []ErrorRatePoint
+	Outages       []*Outage
+	Downtime      time.Duration
+	Downtimes     []DowntimeWindow
+	Aborted       bool
+	Interrupted   bool
+	Fingerprints  []*FingerprintReport
+	Targets       []*TargetReport
+	Routes        map[string]*RouteReport
+	Comparison    *ComparisonReport
+	Shadow        *ShadowReport
+	Assertions    []*AssertionResult
 }
 
-type RecordReport struct {
-	DSN         string
-	Files       []string
-	PreQueries  []string
-	Started     time.Time
-	Finished    time.Time
-	Elapsed     time.Duration
+// FingerprintReport is the summary of a query shape. Latency has the metrics of ComparedLatencies.
+type FingerprintReport struct {
+	Fingerprint string
 	Queries     int
-	NAgents     int
-	Rate        int
 	QPS         float64
-	MaxQPS      float64
-	MinQPS      float64
-	MedianQPS   float64
-	ExpectedQPS int
-	LoopCount   int64
-	Response    *tachymeter.Metrics
-	Token       string
-	GOMAXPROCS  int
+	Errors      int
+	Latency     map[string]time.Duration
+}
+
+type TargetReport struct {
+	DSN       string
+	NAgents   int
+	Queries   int
+	QPS       float64
+	Errors    int
+	ErrorRate float64
+	Response  *tachymeter.Metrics
+}
+
+type latencySum struct {
+	count int
+	sum   time.Duration
 }
 
 type DataPoint struct {
 	Time         time.Time
 	ResponseTime time.Duration
+	Fingerprint  string
+	Error        string
+	Agent        int
+	Target       int
+	Route        string
+	Rows         int64
+	Mismatch     bool
+}
+
+type ErrorStat struct {
+	Count  int
+	Sample string
+}
+
+type ErrorRatePoint struct {
+	Time      time.Time
+	Queries   int
+	Errors    int
+	Timeouts  int
+	ErrorRate float64
+}
+
+type Annotation struct {
+	Time time.Time
+	Text string
+}
+
+type ConcurrencyPoint struct {
+	Time    time.Time
+	NAgents int
 }
 
 func (recorder *Recorder) AppendResponseTimes(responseTimes []DataPoint) {
 	recorder.Lock()
 	defer recorder.Unlock()
-	recorder.ResponseTimes = append(recorder.ResponseTimes, responseTimes...)
+
+	for _, v := range responseTimes {
+		if v.Route == RouteShadow {
+			recorder.ShadowPoints = append(recorder.ShadowPoints, v)
+		} else if v.Error != "" {
+			recorder.ErrorPoints = append(recorder.ErrorPoints, v)
+		} else {
+			recorder.ResponseTimes = append(recorder.ResponseTimes, v)
+
+			if v.Target < len(recorder.targetLatency) {
+				recorder.targetLatency[v.Target].count++
+				recorder.targetLatency[v.Target].sum += v.ResponseTime
+			}
+		}
+	}
 }
 
 func (recorder *Recorder) Start(bufsize int) {
 	recorder.ResponseTimes = []DataPoint{}
+	recorder.ErrorPoints = []DataPoint{}
+	recorder.ShadowPoints = []DataPoint{}
+	recorder.ErrorSamples = map[string]string{}
+	recorder.targetLatency = make([]latencySum, len(recorder.Targets))
 	ch := make(chan []DataPoint, bufsize)
 	recorder.Channel = ch
+	recorder.done = make(chan struct{})
 
 	go func() {
 		for responseTimes := range ch {
 			recorder.AppendResponseTimes(responseTimes)
+
+			for _, o := range recorder.Observers {
+				o.Observe(responseTimes)
+			}
 		}
+
+		close(recorder.done)
 	}()
 
 	recorder.Started = time.Now()
@@ -78,22 +205,195 @@ func (recorder *Recorder) Add(responseTimes []DataPoint) {
 	recorder.Channel <- responseTimes
 }
 
+// AddConcurrency records the number of running agents if it has changed since the last record.
+func (recorder *Recorder) AddConcurrency(nagents int) {
+	recorder.Lock()
+	defer recorder.Unlock()
+
+	if n := len(recorder.Concurrency); n > 0 && recorder.Concurrency[n-1].NAgents == nagents {
+		return
+	}
+
+	recorder.Concurrency = append(recorder.Concurrency, ConcurrencyPoint{
+		Time:    time.Now(),
+		NAgents: nagents,
+	})
+}
+
+func (recorder *Recorder) Annotate(text string) {
+	recorder.Lock()
+	defer recorder.Unlock()
+
+	recorder.Annotations = append(recorder.Annotations, Annotation{
+		Time: time.Now(),
+		Text: text,
+	})
+}
+
+// MeanLatency returns the mean response time of the target so far.
+func (recorder *Recorder) MeanLatency(target int) (time.Duration, bool) {
+	recorder.Lock()
+	defer recorder.Unlock()
+
+	if target >= len(recorder.targetLatency) || recorder.targetLatency[target].count == 0 {
+		return 0, false
+	}
+
+	ls := recorder.targetLatency[target]
+
+	return ls.sum / time.Duration(ls.count), true
+}
+
+// SampleError keeps the first error message of each error class.
+func (recorder *Recorder) SampleError(class string, err error) {
+	recorder.Lock()
+	defer recorder.Unlock()
+
+	if _, ok := recorder.ErrorSamples[class]; !ok {
+		recorder.ErrorSamples[class] = err.Error()
+	}
+}
+
+// AddConnectTime records the time to establish a connection (dial, TLS and authentication).
+func (recorder *Recorder) AddConnectTime(d time.Duration) {
+	recorder.Lock()
+	defer recorder.Unlock()
+	recorder.ConnectTimes = append(recorder.ConnectTimes, d)
+}
+
+func (recorder *Recorder) AddOutage(outage *Outage) {
+	recorder.Lock()
+	defer recorder.Unlock()
+	recorder.Outages = append(recorder.Outages, outage)
+}
+
 func (recorder *Recorder) Close() {
 	close(recorder.Channel)
-	recorder.Finished = time.Now()
+	<-recorder.done
+	recorder.finish(time.Now())
+}
+
+// finish calculates the metrics of the run finished at the time.
+func (recorder *Recorder) finish(finished time.Time) {
+	recorder.Finished = finished
+
+	if recorder.TimeSeries != nil {
+		recorder.TimeSeries.finish(recorder.Finished)
+	}
 
+	if recorder.HistogramLog != nil {
+		recorder.HistogramLog.finish(recorder.Finished)
+	}
+
+	recorder.Metrics = recorder.calcMetrics(recorder.latencies())
+	recorder.calcQPS()
+
+	if len(recorder.ConnectTimes) > 0 {
+		recorder.ConnectMetrics = recorder.calcDurationMetrics(recorder.ConnectTimes)
+	}
+}
+
+// latencies returns the samples for the latency metrics.
+// Timed-out queries are excluded unless ClampTimeouts is set, in which case they are included with the timeout as their response time.
+func (recorder *Recorder) latencies() []DataPoint {
+	if !recorder.ClampTimeouts {
+		return recorder.ResponseTimes
+	}
+
+	latencies := make([]DataPoint, len(recorder.ResponseTimes), len(recorder.ResponseTimes)+len(recorder.ErrorPoints))
+	copy(latencies, recorder.ResponseTimes)
+
+	for _, v := range recorder.ErrorPoints {
+		if v.Error == ErrorClassTimeout {
+			latencies = append(latencies, v)
+		}
+	}
+
+	return latencies
+}
+
+func (recorder *Recorder) calcMetrics(responseTimes []DataPoint) *tachymeter.Metrics {
+	durations := make([]time.Duration, len(responseTimes))
+
+	for i, v := range responseTimes {
+		durations[i] = v.ResponseTime
+	}
+
+	return recorder.calcDurationMetrics(durations)
+}
+
+func (recorder *Recorder) calcDurationMetrics(durations []time.Duration) *tachymeter.Metrics {
 	t := tachymeter.New(&tachymeter.Config{
-		Size:      len(recorder.ResponseTimes),
+		Size:      len(durations),
 		HBins:     recorder.HBins,
 		HInterval: recorder.HInterval,
 	})
 
-	for _, v := range recorder.ResponseTimes {
-		t.AddTime(v.ResponseTime)
+	for _, v := range durations {
+		t.AddTime(v)
 	}
 
-	recorder.Metrics = t.Calc()
-	recorder.calcQPS()
+	return t.Calc()
+}
+
+// Interim returns a partial report of a running test for continuous assertions.
+func (recorder *Recorder) Interim() *RecordReport {
+	recorder.Lock()
+	queries := len(recorder.ResponseTimes)
+	latencies := recorder.latencies()
+	responseTimes := make([]DataPoint, len(latencies))
+	copy(responseTimes, latencies)
+	errors, timeouts := countErrors(recorder.ErrorPoints)
+	recorder.Unlock()
+
+	report := &RecordReport{
+		Queries:  queries,
+		Response: recorder.calcMetrics(responseTimes),
+		Errors:   errors,
+		Timeouts: timeouts,
+	}
+
+	report.calcErrorRate()
+
+	return report
+}
+
+// calcErrorRate calculates the rates of errors and timeouts out of all executed queries.
+func (report *RecordReport) calcErrorRate() {
+	if total := report.Queries + report.Errors + report.Timeouts; total > 0 {
+		report.ErrorRate = float64(report.Errors) / float64(total)
+		report.TimeoutRate = float64(report.Timeouts) / float64(total)
+	}
+}
+
+// countErrors returns the number of errors and timeouts.
+// A failed query is counted exactly once: a timed-out query is counted only as a timeout, not as an error.
+func countErrors(points []DataPoint) (int, int) {
+	errors, timeouts := 0, 0
+
+	for _, v := range points {
+		if v.Error == ErrorClassTimeout {
+			timeouts++
+		} else {
+			errors++
+		}
+	}
+
+	return errors, timeouts
+}
+
+func (report *RecordReport) AssertionFailed() bool {
+	if report.Aborted {
+		return true
+	}
+
+	for _, v := range report.Assertions {
+		if !v.Passed {
+			return true
+		}
+	}
+
+	return false
 }
 
 func (recorder *Recorder) calcQPS() {
@@ -150,11 +450,12 @@ func (recorder *Recorder) Report() *RecordReport {
 	nanoElapsed := recorder.Finished.Sub(recorder.Started)
 	count := recorder.Count()
 
-	if len(recorder.QPSHistory) < 1 {
-		return &RecordReport{}
-	}
+	// the QPS history is empty if no query succeeded, e.g. all queries failed
+	qpsHist := []float64{}
 
-	qpsHist := recorder.QPSHistory[1:]
+	if len(recorder.QPSHistory) > 0 {
+		qpsHist = recorder.QPSHistory[1:]
+	}
 
 	report := &RecordReport{
 		DSN:         recorder.DSN,
@@ -166,12 +467,29 @@ func (recorder *Recorder) Report() *RecordReport {
 		Queries:     count,
 		NAgents:     recorder.NAgents,
 		Rate:        recorder.Rate,
-		QPS:         float64(count) * float64(time.Second) / float64(nanoElapsed),
 		ExpectedQPS: recorder.NAgents * recorder.Rate,
 		LoopCount:   recorder.LoopCount,
 		Response:    recorder.Metrics,
+		Connects:    len(recorder.ConnectTimes),
+		Connect:     recorder.ConnectMetrics,
 		Token:       recorder.Token,
 		GOMAXPROCS:  runtime.GOMAXPROCS(0),
+		Concurrency: recorder.Concurrency,
+		Annotations: recorder.Annotations,
+		Aborted:     recorder.Aborted,
+		Interrupted: recorder.Interrupted,
+	}
+
+	if nanoElapsed > 0 {
+		report.QPS = float64(count) * float64(time.Second) / float64(nanoElapsed)
+	}
+
+	recorder.calcErrors(report)
+
+	for _, v := range recorder.Concurrency {
+		if v.NAgents > report.MaxAgents {
+			report.MaxAgents = v.NAgents
+		}
 	}
 
 	if len(qpsHist) > 0 {
@@ -206,9 +524,209 @@ func (recorder *Recorder) Report() *RecordReport {
 		}
 	}
 
+	report.Fingerprints = recorder.fingerprintReports()
+
+	if len(recorder.Targets) > 1 {
+		report.Targets = recorder.targetReports()
+
+		if recorder.Compare {
+			report.Comparison = recorder.comparisonReport(report.Targets)
+		}
+	}
+
+	if len(recorder.Replicas) > 0 {
+		report.Routes = recorder.routeReports()
+	}
+
+	if recorder.Shadow != "" {
+		report.Shadow = recorder.shadowReport()
+	}
+
+	report.Assertions = recorder.Assertions.Check(report)
+
 	return report
 }
 
+func (recorder *Recorder) targetReports() []*TargetReport {
+	nanoElapsed := recorder.Finished.Sub(recorder.Started)
+	responseTimes := make([][]DataPoint, len(recorder.Targets))
+	reports := make([]*TargetReport, len(recorder.Targets))
+
+	for i, t := range recorder.Targets {
+		reports[i] = &TargetReport{
+			DSN:     t.ConnInfo.DSN,
+			NAgents: t.NAgents,
+		}
+	}
+
+	for _, v := range recorder.ResponseTimes {
+		responseTimes[v.Target] = append(responseTimes[v.Target], v)
+	}
+
+	for _, v := range recorder.ErrorPoints {
+		reports[v.Target].Errors++
+	}
+
+	for i, r := range reports {
+		r.Queries = len(responseTimes[i])
+		r.QPS = float64(r.Queries) * float64(time.Second) / float64(nanoElapsed)
+		r.Response = recorder.calcMetrics(responseTimes[i])
+
+		if total := r.Queries + r.Errors; total > 0 {
+			r.ErrorRate = float64(r.Errors) / float64(total)
+		}
+	}
+
+	return reports
+}
+
+// fingerprintReports returns the reports of the MaxReportFingerprints most frequent query shapes.
+func (recorder *Recorder) fingerprintReports() []*FingerprintReport {
+	nanoElapsed := recorder.Finished.Sub(recorder.Started)
+	responseTimes := map[string][]DataPoint{}
+	reports := map[string]*FingerprintReport{}
+
+	fingerprint := func(v DataPoint) *FingerprintReport {
+		r, ok := reports[v.Fingerprint]
+
+		if !ok {
+			r = &FingerprintReport{Fingerprint: v.Fingerprint}
+			reports[v.Fingerprint] = r
+		}
+
+		return r
+	}
+
+	for _, v := range recorder.ResponseTimes {
+		fingerprint(v).Queries++
+		responseTimes[v.Fingerprint] = append(responseTimes[v.Fingerprint], v)
+	}
+
+	for _, v := range recorder.ErrorPoints {
+		fingerprint(v).Errors++
+	}
+
+	list := make([]*FingerprintReport, 0, len(reports))
+
+	for _, r := range reports {
+		list = append(list, r)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		ni, nj := list[i].Queries+list[i].Errors, list[j].Queries+list[j].Errors
+
+		if ni != nj {
+			return ni > nj
+		}
+
+		return list[i].Fingerprint < list[j].Fingerprint
+	})
+
+	if len(list) > MaxReportFingerprints {
+		list = list[:MaxReportFingerprints]
+	}
+
+	for _, r := range list {
+		r.QPS = float64(r.Queries) * float64(time.Second) / float64(nanoElapsed)
+
+		if r.Queries == 0 {
+			continue
+		}
+
+		metrics := &RecordReport{Response: recorder.calcMetrics(responseTimes[r.Fingerprint])}
+		r.Latency = map[string]time.Duration{}
+
+		for _, name := range ComparedLatencies {
+			r.Latency[name] = latencyMetrics[name](metrics)
+		}
+	}
+
+	return list
+}
+
+func (recorder *Recorder) calcErrors(report *RecordReport) {
+	report.Errors, report.Timeouts = countErrors(recorder.ErrorPoints)
+	report.calcErrorRate()
+
+	if report.Errors == 0 && report.Timeouts == 0 {
+		return
+	}
+
+	report.ErrorTimeline = recorder.errorTimeline()
+
+	if report.Errors > 0 {
+		report.ErrorsByClass = map[string]*ErrorStat{}
+		report.ErrorsByQuery = map[string]int{}
+	}
+
+	for _, v := range recorder.ErrorPoints {
+		if v.Error == ErrorClassTimeout {
+			continue
+		}
+
+		stat, ok := report.ErrorsByClass[v.Error]
+
+		if !ok {
+			stat = &ErrorStat{Sample: recorder.ErrorSamples[v.Error]}
+			report.ErrorsByClass[v.Error] = stat
+		}
+
+		stat.Count++
+		report.ErrorsByQuery[v.Fingerprint]++
+	}
+
+	if len(recorder.Outages) > 0 {
+		report.Outages = recorder.Outages
+		report.Downtimes = downtimeWindows(recorder.Outages, recorder.Finished)
+
+		for _, v := range report.Downtimes {
+			report.Downtime += v.Duration
+		}
+	}
+}
+
+// errorTimeline returns the error rate for each second of the run.
+func (recorder *Recorder) errorTimeline() []ErrorRatePoint {
+	n := int(recorder.Finished.Sub(recorder.Started)/time.Second) + 1
+	timeline := make([]ErrorRatePoint, n)
+
+	for i := range timeline {
+		timeline[i].Time = recorder.Started.Add(time.Duration(i) * time.Second)
+	}
+
+	bucket := func(t time.Time) *ErrorRatePoint {
+		i := int(t.Sub(recorder.Started) / time.Second)
+
+		if i < 0 {
+			i = 0
+		} else if i >= n {
+			i = n - 1
+		}
+
+		return &timeline[i]
+	}
+
+	for _, v := range recorder.ResponseTimes {
+		bucket(v.Time).Queries++
+	}
+
+	for _, v := range recorder.ErrorPoints {
+		if v.Error == ErrorClassTimeout {
+			bucket(v.Time).Timeouts++
+		} else {
+			bucket(v.Time).Errors++
+		}
+	}
+
+	for i := range timeline {
+		if total := timeline[i].Queries + timeline[i].Errors + timeline[i].Timeouts; total > 0 {
+			timeline[i].ErrorRate = float64(timeline[i].Errors) / float64(total)
+		}
+	}
+
+	return timeline
+}
+
 func (recorder *Recorder) WriteHTMLFile(fname string, title string) error {
 	return recorder.Metrics.WriteHTMLFile(fname, title)
 }
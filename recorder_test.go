@@ -0,0 +1,77 @@
+package qrn
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestReportAllErrors(t *testing.T) {
+	recorder := &Recorder{
+		DSN:     "fake:error-rate=1",
+		Files:   []string{"data.jsonl"},
+		NAgents: 2,
+		Token:   "token",
+	}
+
+	recorder.Start(10)
+	now := time.Now()
+
+	recorder.Add([]DataPoint{
+		{Time: now, ResponseTime: time.Millisecond, Fingerprint: "select ?", Error: "fake"},
+		{Time: now, ResponseTime: time.Millisecond, Fingerprint: "select ?", Error: "fake", Agent: 1},
+		{Time: now, ResponseTime: time.Second, Fingerprint: "update t set a = ?", Error: ErrorClassTimeout},
+	})
+
+	time.Sleep(10 * time.Millisecond)
+	recorder.Close()
+	report := recorder.Report()
+
+	if report.DSN != recorder.DSN || len(report.Files) != 1 || report.NAgents != 2 || report.Token != "token" {
+		t.Errorf("common fields are missing: %+v", report)
+	}
+
+	if !report.Started.Equal(recorder.Started) || !report.Finished.Equal(recorder.Finished) {
+		t.Errorf("expected %s-%s, got %s-%s", recorder.Started, recorder.Finished, report.Started, report.Finished)
+	}
+
+	if report.Queries != 0 || report.QPS != 0 || report.Errors != 2 || report.Timeouts != 1 {
+		t.Errorf("expected 0 queries, 2 errors and 1 timeout, got %d queries (%f qps), %d errors and %d timeouts",
+			report.Queries, report.QPS, report.Errors, report.Timeouts)
+	}
+
+	if report.ErrorRate != 2.0/3 || report.TimeoutRate != 1.0/3 {
+		t.Errorf("unexpected rates: %f, %f", report.ErrorRate, report.TimeoutRate)
+	}
+
+	if stat := report.ErrorsByClass["fake"]; stat == nil || stat.Count != 2 {
+		t.Errorf("unexpected errors by class: %+v", report.ErrorsByClass)
+	}
+
+	if len(report.Fingerprints) != 2 || report.Fingerprints[0].Fingerprint != "select ?" || report.Fingerprints[0].Errors != 2 {
+		t.Errorf("unexpected fingerprints: %+v", report.Fingerprints)
+	}
+
+	// the report can be written without latency metrics
+	var buf bytes.Buffer
+
+	for _, format := range []string{ReportFormatJSON, ReportFormatMarkdown, ReportFormatCSV, ReportFormatJUnit} {
+		if err := report.WriteReport(&buf, format, true); err != nil {
+			t.Errorf("%s: %s", format, err)
+		}
+	}
+}
+
+func TestReportWithoutQueries(t *testing.T) {
+	recorder := &Recorder{DSN: "fake:", NAgents: 1}
+	recorder.Start(1)
+	recorder.Close()
+
+	// finished at the start
+	recorder.Finished = recorder.Started
+	report := recorder.Report()
+
+	if report.DSN != "fake:" || report.NAgents != 1 || report.QPS != 0 || report.Errors != 0 {
+		t.Errorf("unexpected report: %+v", report)
+	}
+}
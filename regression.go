@@ -0,0 +1,223 @@
+package qrn
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"time"
+
+	"github.com/winebarrel/tachymeter"
+)
+
+// RegressionLatencies are the latency metrics compared between reports.
+var RegressionLatencies = []string{"p50", "p75", "p95", "p99", "p999", "avg", "max"}
+
+// MinFingerprintQueries is the minimum number of queries of a query shape in both reports to be compared.
+const MinFingerprintQueries = 100
+
+// Tolerances are the allowed relative changes of QPS (decrease) and latency (increase),
+// and the allowed absolute increase of the error rate.
+type Tolerances struct {
+	QPS       float64
+	Latency   float64
+	ErrorRate float64
+}
+
+var DefaultTolerances = Tolerances{
+	QPS:       0.05,
+	Latency:   0.1,
+	ErrorRate: 0.001,
+}
+
+// RegressionReport is the comparison of a report (B) against a baseline report (A).
+type RegressionReport struct {
+	Base         string
+	New          string
+	Tolerances   Tolerances
+	QPS          *Delta
+	ErrorRate    *Delta
+	Latency      map[string]*LatencyDelta
+	Regressions  []string
+	Fingerprints []*FingerprintDelta
+}
+
+type metricsJSON struct {
+	Time      map[string]string
+	Rate      struct{ Second float64 }
+	Histogram *tachymeter.Histogram
+	Samples   int
+	Count     int
+}
+
+// metrics decodes tachymeter.Metrics, which is encoded with durations as strings.
+func (m *metricsJSON) metrics() (*tachymeter.Metrics, error) {
+	metrics := &tachymeter.Metrics{
+		Histogram: m.Histogram,
+		Samples:   m.Samples,
+		Count:     m.Count,
+	}
+
+	metrics.Rate.Second = m.Rate.Second
+
+	fields := map[string]*time.Duration{
+		"Cumulative": &metrics.Time.Cumulative,
+		"HMean":      &metrics.Time.HMean,
+		"Avg":        &metrics.Time.Avg,
+		"P50":        &metrics.Time.P50,
+		"P75":        &metrics.Time.P75,
+		"P95":        &metrics.Time.P95,
+		"P99":        &metrics.Time.P99,
+		"P999":       &metrics.Time.P999,
+		"Long5p":     &metrics.Time.Long5p,
+		"Short5p":    &metrics.Time.Short5p,
+		"Max":        &metrics.Time.Max,
+		"Min":        &metrics.Time.Min,
+		"StdDev":     &metrics.Time.StdDev,
+		"Range":      &metrics.Time.Range,
+	}
+
+	for k, v := range m.Time {
+		d, ok := fields[k]
+
+		if !ok {
+			continue
+		}
+
+		var err error
+		*d, err = time.ParseDuration(v)
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", k, err)
+		}
+	}
+
+	return metrics, nil
+}
+
+// LoadRecordReport reads the JSON output of qrn.
+// Only the overall metrics are loaded: Targets, Routes, Comparison and Shadow are not.
+func LoadRecordReport(path string) (*RecordReport, error) {
+	raw, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	type plainRecordReport RecordReport
+	report := &RecordReport{}
+
+	aux := &struct {
+		*plainRecordReport
+		Response   *metricsJSON
+		Connect    *metricsJSON
+		Targets    json.RawMessage
+		Routes     json.RawMessage
+		Comparison json.RawMessage
+		Shadow     json.RawMessage
+	}{plainRecordReport: (*plainRecordReport)(report)}
+
+	err = json.Unmarshal(raw, aux)
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid report: %s: %w", path, err)
+	}
+
+	if aux.Response != nil {
+		report.Response, err = aux.Response.metrics()
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid report: %s: Response: %w", path, err)
+		}
+	}
+
+	if aux.Connect != nil {
+		report.Connect, err = aux.Connect.metrics()
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid report: %s: Connect: %w", path, err)
+		}
+	}
+
+	if report.Response == nil {
+		return nil, fmt.Errorf("invalid report: %s: no response metrics", path)
+	}
+
+	return report, nil
+}
+
+// CompareReports compares b against the baseline a. Fingerprints are the regressed query shapes, the worst first.
+func CompareReports(a *RecordReport, b *RecordReport, tolerances Tolerances) *RegressionReport {
+	report := &RegressionReport{
+		Base:       a.Token,
+		New:        b.Token,
+		Tolerances: tolerances,
+		QPS:        newDelta(a.QPS, b.QPS),
+		ErrorRate:  newDelta(a.ErrorRate, b.ErrorRate),
+		Latency:    map[string]*LatencyDelta{},
+	}
+
+	if report.QPS.Change < -tolerances.QPS {
+		report.Regressions = append(report.Regressions, "qps")
+	}
+
+	if report.ErrorRate.Diff > tolerances.ErrorRate {
+		report.Regressions = append(report.Regressions, "errors")
+	}
+
+	for _, name := range RegressionLatencies {
+		metric := latencyMetrics[name]
+		d := newLatencyDelta(metric(a), metric(b))
+		report.Latency[name] = d
+
+		if d.Change > tolerances.Latency {
+			report.Regressions = append(report.Regressions, name)
+		}
+	}
+
+	base := map[string]*FingerprintReport{}
+
+	for _, fp := range a.Fingerprints {
+		base[fp.Fingerprint] = fp
+	}
+
+	for _, fb := range b.Fingerprints {
+		fa, ok := base[fb.Fingerprint]
+
+		if !ok || fa.Queries < MinFingerprintQueries || fb.Queries < MinFingerprintQueries {
+			continue
+		}
+
+		d := &FingerprintDelta{
+			Fingerprint: fb.Fingerprint,
+			Queries:     [2]int{fa.Queries, fb.Queries},
+			Errors:      [2]int{fa.Errors, fb.Errors},
+			Latency:     map[string]*LatencyDelta{},
+		}
+
+		for _, name := range ComparedLatencies {
+			d.Latency[name] = newLatencyDelta(fa.Latency[name], fb.Latency[name])
+		}
+
+		if d.Latency["p99"].Change > tolerances.Latency {
+			report.Fingerprints = append(report.Fingerprints, d)
+		}
+	}
+
+	sort.Slice(report.Fingerprints, func(i, j int) bool {
+		ci, cj := report.Fingerprints[i].Latency["p99"].Change, report.Fingerprints[j].Latency["p99"].Change
+
+		if ci != cj {
+			return ci > cj
+		}
+
+		return report.Fingerprints[i].Fingerprint < report.Fingerprints[j].Fingerprint
+	})
+
+	return report
+}
+
+// Regressed returns true if a metric or a query shape regressed.
+func (report *RegressionReport) Regressed() bool {
+	return len(report.Regressions) > 0 || len(report.Fingerprints) > 0
+}
@@ -0,0 +1,201 @@
+package qrn
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/winebarrel/tachymeter"
+)
+
+func TestLoadRecordReport(t *testing.T) {
+	tm := tachymeter.New(&tachymeter.Config{Size: 100, HBins: 5})
+
+	for i := 1; i <= 100; i++ {
+		tm.AddTime(time.Duration(i) * time.Millisecond)
+	}
+
+	report := testReport()
+	report.Response = tm.Calc()
+	report.Connect = tm.Calc()
+	report.Targets = []*TargetReport{{DSN: "dsn", Queries: 1}}
+	report.calcErrorRate()
+
+	var buf bytes.Buffer
+	err := report.WriteJSON(&buf)
+
+	// tachymeter does not encode the bin size
+	report.Response.HistogramBinSize = 0
+	report.Connect.HistogramBinSize = 0
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "report.json")
+	err = ioutil.WriteFile(path, buf.Bytes(), 0644)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := LoadRecordReport(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(loaded.Response, report.Response) {
+		t.Errorf("expected %+v, got %+v", report.Response, loaded.Response)
+	}
+
+	if !reflect.DeepEqual(loaded.Connect, report.Connect) {
+		t.Errorf("expected %+v, got %+v", report.Connect, loaded.Connect)
+	}
+
+	if loaded.Targets != nil {
+		t.Errorf("expected no targets, got %+v", loaded.Targets)
+	}
+
+	// the rest is loaded as is
+	loaded.Response, loaded.Connect = report.Response, report.Connect
+	loaded.Targets = report.Targets
+
+	if !reflect.DeepEqual(loaded, report) {
+		t.Errorf("expected %+v, got %+v", report, loaded)
+	}
+}
+
+func TestLoadRecordReportError(t *testing.T) {
+	dir := t.TempDir()
+
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"invalid.json", "{"},
+		{"no-response.json", `{"Queries":1}`},
+		{"invalid-duration.json", `{"Response":{"Time":{"P99":"abc"}}}`},
+	}
+
+	for _, tt := range tests {
+		path := filepath.Join(dir, tt.name)
+		err := ioutil.WriteFile(path, []byte(tt.content), 0644)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := LoadRecordReport(path); err == nil {
+			t.Errorf("%s: expected an error", tt.name)
+		}
+	}
+
+	if _, err := LoadRecordReport(filepath.Join(dir, "missing.json")); err == nil {
+		t.Error("expected an error for a missing file")
+	}
+}
+
+// regressionReport returns a report with the latency scaled from a baseline.
+func regressionReport(qps float64, errorRate float64, scale float64, fingerprints ...*FingerprintReport) *RecordReport {
+	ms := func(n float64) time.Duration { return time.Duration(n * scale * float64(time.Millisecond)) }
+	metrics := &tachymeter.Metrics{}
+	metrics.Time.P50 = ms(1)
+	metrics.Time.P75 = ms(2)
+	metrics.Time.P95 = ms(4)
+	metrics.Time.P99 = ms(8)
+	metrics.Time.P999 = ms(16)
+	metrics.Time.Avg = ms(2)
+	metrics.Time.Max = ms(32)
+
+	return &RecordReport{
+		QPS:          qps,
+		ErrorRate:    errorRate,
+		Response:     metrics,
+		Fingerprints: fingerprints,
+	}
+}
+
+func fingerprintReport(fingerprint string, queries int, p99 time.Duration) *FingerprintReport {
+	return &FingerprintReport{
+		Fingerprint: fingerprint,
+		Queries:     queries,
+		Latency:     map[string]time.Duration{"p50": p99 / 4, "p95": p99 / 2, "p99": p99, "avg": p99 / 4, "max": p99 * 2},
+	}
+}
+
+func TestCompareReports(t *testing.T) {
+	tests := []struct {
+		name        string
+		b           *RecordReport
+		regressions []string
+	}{
+		{"same", regressionReport(1000, 0.01, 1), nil},
+		{"within tolerances", regressionReport(960, 0.0105, 1.09), nil},
+		{"faster", regressionReport(2000, 0, 0.5), nil},
+		{"qps", regressionReport(900, 0.01, 1), []string{"qps"}},
+		{"errors", regressionReport(1000, 0.012, 1), []string{"errors"}},
+		{"latency", regressionReport(1000, 0.01, 1.2), []string{"p50", "p75", "p95", "p99", "p999", "avg", "max"}},
+	}
+
+	a := regressionReport(1000, 0.01, 1)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report := CompareReports(a, tt.b, DefaultTolerances)
+
+			if !reflect.DeepEqual(report.Regressions, tt.regressions) {
+				t.Errorf("expected regressions %v, got %v", tt.regressions, report.Regressions)
+			}
+
+			if report.Regressed() != (len(tt.regressions) > 0) {
+				t.Errorf("unexpected Regressed(): %v", report.Regressed())
+			}
+		})
+	}
+}
+
+func TestCompareReportsFingerprints(t *testing.T) {
+	a := regressionReport(1000, 0, 1,
+		fingerprintReport("select ?", 1000, 10*time.Millisecond),
+		fingerprintReport("update t set a = ?", 1000, 10*time.Millisecond),
+		fingerprintReport("delete from t", 1000, 10*time.Millisecond),
+		fingerprintReport("insert into t values (?)", MinFingerprintQueries-1, 10*time.Millisecond),
+	)
+
+	b := regressionReport(1000, 0, 1,
+		fingerprintReport("select ?", 1000, 12*time.Millisecond),
+		fingerprintReport("update t set a = ?", 1000, 20*time.Millisecond),
+		fingerprintReport("delete from t", 1000, 10*time.Millisecond),
+		// too few queries in the baseline to be compared
+		fingerprintReport("insert into t values (?)", 1000, 100*time.Millisecond),
+		// not in the baseline
+		fingerprintReport("select now()", 1000, 100*time.Millisecond),
+	)
+
+	report := CompareReports(a, b, DefaultTolerances)
+
+	if len(report.Regressions) != 0 || !report.Regressed() {
+		t.Errorf("expected regressions only in query shapes: %v", report.Regressions)
+	}
+
+	fingerprints := []string{}
+
+	for _, v := range report.Fingerprints {
+		fingerprints = append(fingerprints, v.Fingerprint)
+	}
+
+	// the worst first
+	expected := []string{"update t set a = ?", "select ?"}
+
+	if !reflect.DeepEqual(fingerprints, expected) {
+		t.Errorf("expected %v, got %v", expected, fingerprints)
+	}
+
+	if d := report.Fingerprints[0].Latency["p99"]; d.Diff != 10*time.Millisecond || d.Change != 1 {
+		t.Errorf("unexpected p99 delta: %+v", d)
+	}
+}
@@ -0,0 +1,321 @@
+package qrn
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	ReportFormatJSON     = "json"
+	ReportFormatMarkdown = "markdown"
+	ReportFormatCSV      = "csv"
+	ReportFormatJUnit    = "junit"
+)
+
+var reportCSVHeader = []string{
+	"started", "elapsed_sec", "nagents", "max_agents", "queries", "qps", "min_qps", "median_qps", "max_qps",
+	"errors", "error_rate", "timeouts", "min_ms", "p50_ms", "p75_ms", "p95_ms", "p99_ms", "p999_ms", "max_ms", "avg_ms",
+	"interrupted", "assertions_passed", "token",
+}
+
+// WriteReport writes the report in the format. header is only used by the CSV format.
+func (report *RecordReport) WriteReport(w io.Writer, format string, header bool) error {
+	switch format {
+	case ReportFormatJSON:
+		return report.WriteJSON(w)
+	case ReportFormatMarkdown:
+		return report.WriteMarkdown(w)
+	case ReportFormatCSV:
+		return report.WriteCSV(w, header)
+	case ReportFormatJUnit:
+		return report.WriteJUnit(w)
+	default:
+		return fmt.Errorf("unknown report format: %s", format)
+	}
+}
+
+func (report *RecordReport) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	return enc.Encode(report)
+}
+
+func ms(d time.Duration) string {
+	return strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 3, 64)
+}
+
+// latencyRow returns min, p50, p75, p95, p99, p999, max and avg.
+func (report *RecordReport) latencyRow(format func(time.Duration) string) []string {
+	row := make([]string, 8)
+
+	if report.Response == nil || report.Response.Count == 0 {
+		return row
+	}
+
+	t := report.Response.Time
+
+	for i, d := range []time.Duration{t.Min, t.P50, t.P75, t.P95, t.P99, t.P999, t.Max, t.Avg} {
+		row[i] = format(d)
+	}
+
+	return row
+}
+
+// WriteCSV writes the report as a row, so that the results of runs can be appended to a file.
+func (report *RecordReport) WriteCSV(w io.Writer, header bool) error {
+	cw := csv.NewWriter(w)
+
+	if header {
+		cw.Write(reportCSVHeader)
+	}
+
+	row := []string{
+		report.Started.Format(time.RFC3339),
+		strconv.FormatInt(int64(report.Elapsed), 10), // in seconds
+		strconv.Itoa(report.NAgents),
+		strconv.Itoa(report.MaxAgents),
+		strconv.Itoa(report.Queries),
+		strconv.FormatFloat(report.QPS, 'f', 2, 64),
+		strconv.FormatFloat(report.MinQPS, 'f', 2, 64),
+		strconv.FormatFloat(report.MedianQPS, 'f', 2, 64),
+		strconv.FormatFloat(report.MaxQPS, 'f', 2, 64),
+		strconv.Itoa(report.Errors),
+		strconv.FormatFloat(report.ErrorRate, 'f', -1, 64),
+		strconv.Itoa(report.Timeouts),
+	}
+
+	row = append(row, report.latencyRow(ms)...)
+	row = append(row, strconv.FormatBool(report.Interrupted), strconv.FormatBool(!report.AssertionFailed()), report.Token)
+	cw.Write(row)
+	cw.Flush()
+
+	return cw.Error()
+}
+
+func (report *RecordReport) WriteMarkdown(w io.Writer) error {
+	p := func(format string, a ...interface{}) {
+		fmt.Fprintf(w, format+"\n", a...)
+	}
+
+	status := "completed"
+
+	if report.Interrupted {
+		status = "interrupted"
+	} else if report.Aborted {
+		status = "aborted"
+	}
+
+	p("## qrn report")
+	p("")
+	p("| | |")
+	p("|---|---|")
+	p("| Status | %s |", status)
+	p("| Started | %s |", report.Started.Format(time.RFC3339))
+	p("| Elapsed | %ds |", report.Elapsed)
+	p("| Agents | %d (max %d) |", report.NAgents, report.MaxAgents)
+	p("| Queries | %d |", report.Queries)
+	p("| QPS | %.1f (min %.1f / median %.1f / max %.1f) |", report.QPS, report.MinQPS, report.MedianQPS, report.MaxQPS)
+	p("| Errors | %d (%.3f%%) |", report.Errors, report.ErrorRate*100)
+
+	if report.Timeouts > 0 {
+		p("| Timeouts | %d (%.3f%%) |", report.Timeouts, report.TimeoutRate*100)
+	}
+
+	if report.Downtime > 0 {
+		p("| Downtime | %s |", report.Downtime)
+	}
+
+	p("")
+	p("### Latency (ms)")
+	p("")
+	p("| min | p50 | p75 | p95 | p99 | p99.9 | max | avg |")
+	p("|---:|---:|---:|---:|---:|---:|---:|---:|")
+	p("| %s |", joinCells(report.latencyRow(ms)))
+
+	if len(report.Targets) > 0 {
+		p("")
+		p("### Targets")
+		p("")
+		p("| # | Agents | Queries | QPS | Errors | p50 (ms) | p99 (ms) |")
+		p("|---:|---:|---:|---:|---:|---:|---:|")
+
+		for i, t := range report.Targets {
+			r := &RecordReport{Response: t.Response}
+			row := r.latencyRow(ms)
+			p("| %d | %d | %d | %.1f | %d | %s | %s |", i, t.NAgents, t.Queries, t.QPS, t.Errors, row[1], row[4])
+		}
+	}
+
+	if len(report.ErrorsByClass) > 0 {
+		p("")
+		p("### Errors")
+		p("")
+		p("| Class | Count | Sample |")
+		p("|---|---:|---|")
+
+		classes := make([]string, 0, len(report.ErrorsByClass))
+
+		for k := range report.ErrorsByClass {
+			classes = append(classes, k)
+		}
+
+		sort.Strings(classes)
+
+		for _, c := range classes {
+			stat := report.ErrorsByClass[c]
+			p("| %s | %d | %s |", escapeMarkdown(c), stat.Count, escapeMarkdown(stat.Sample))
+		}
+	}
+
+	if len(report.Assertions) > 0 {
+		p("")
+		p("### Assertions")
+		p("")
+		p("| Assertion | Actual | Result |")
+		p("|---|---:|---|")
+
+		for _, v := range report.Assertions {
+			result := "PASS"
+
+			if !v.Passed {
+				result = "FAIL"
+			}
+
+			p("| `%s` | %s | %s |", v.Assertion, v.Actual, result)
+		}
+	}
+
+	return nil
+}
+
+func joinCells(cells []string) string {
+	s := ""
+
+	for i, c := range cells {
+		if i > 0 {
+			s += " | "
+		}
+
+		s += c
+	}
+
+	return s
+}
+
+func escapeMarkdown(s string) string {
+	escaped := []rune{}
+
+	for _, r := range s {
+		switch r {
+		case '|', '\\', '`', '*', '_':
+			escaped = append(escaped, '\\', r)
+		case '\n':
+			escaped = append(escaped, ' ')
+		default:
+			escaped = append(escaped, r)
+		}
+	}
+
+	return string(escaped)
+}
+
+type junitProperty struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+}
+
+type junitTestSuite struct {
+	XMLName    xml.Name        `xml:"testsuite"`
+	Name       string          `xml:"name,attr"`
+	Tests      int             `xml:"tests,attr"`
+	Failures   int             `xml:"failures,attr"`
+	Time       string          `xml:"time,attr"`
+	Timestamp  string          `xml:"timestamp,attr"`
+	Properties []junitProperty `xml:"properties>property"`
+	TestCases  []junitTestCase `xml:"testcase"`
+}
+
+type junitTestSuites struct {
+	XMLName xml.Name `xml:"testsuites"`
+	Suites  []junitTestSuite
+}
+
+// WriteJUnit writes the report as JUnit XML. Each assertion is a test case.
+// An aborted or interrupted run is a failed "run" test case.
+func (report *RecordReport) WriteJUnit(w io.Writer) error {
+	suite := junitTestSuite{
+		Name:      "qrn",
+		Time:      strconv.FormatInt(int64(report.Elapsed), 10),
+		Timestamp: report.Started.Format("2006-01-02T15:04:05"),
+		Properties: []junitProperty{
+			{Name: "token", Value: report.Token},
+			{Name: "queries", Value: strconv.Itoa(report.Queries)},
+			{Name: "qps", Value: strconv.FormatFloat(report.QPS, 'f', 2, 64)},
+			{Name: "errors", Value: strconv.Itoa(report.Errors)},
+		},
+	}
+
+	run := junitTestCase{Name: "run", ClassName: "qrn", Time: suite.Time}
+
+	if report.Interrupted {
+		run.Failure = &junitFailure{Message: "interrupted", Type: "interrupted"}
+	} else if report.Aborted {
+		run.Failure = &junitFailure{Message: "aborted by a failed assertion", Type: "aborted"}
+	}
+
+	suite.TestCases = append(suite.TestCases, run)
+
+	for _, v := range report.Assertions {
+		tc := junitTestCase{Name: v.Assertion, ClassName: "qrn.assertions", Time: "0"}
+
+		if !v.Passed {
+			tc.Failure = &junitFailure{
+				Message: fmt.Sprintf("%s (actual %s)", v.Assertion, v.Actual),
+				Type:    "assertion",
+				Text:    fmt.Sprintf("expected %s, actual %s", v.Assertion, v.Actual),
+			}
+		}
+
+		suite.TestCases = append(suite.TestCases, tc)
+	}
+
+	for _, tc := range suite.TestCases {
+		suite.Tests++
+
+		if tc.Failure != nil {
+			suite.Failures++
+		}
+	}
+
+	io.WriteString(w, xml.Header)
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	err := enc.Encode(junitTestSuites{Suites: []junitTestSuite{suite}})
+
+	if err != nil {
+		return err
+	}
+
+	_, err = io.WriteString(w, "\n")
+	return err
+}
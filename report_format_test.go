@@ -0,0 +1,172 @@
+package qrn
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/winebarrel/tachymeter"
+)
+
+func testReport() *RecordReport {
+	response := &tachymeter.Metrics{Count: 1000, Samples: 1000}
+	response.Time.Min = 500 * time.Microsecond
+	response.Time.P50 = time.Millisecond
+	response.Time.P75 = 1500 * time.Microsecond
+	response.Time.P95 = 3 * time.Millisecond
+	response.Time.P99 = 5 * time.Millisecond
+	response.Time.P999 = 8 * time.Millisecond
+	response.Time.Max = 12 * time.Millisecond
+	response.Time.Avg = 1250 * time.Microsecond
+
+	return &RecordReport{
+		Started:   time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC),
+		Elapsed:   10,
+		NAgents:   4,
+		MaxAgents: 4,
+		Queries:   990,
+		QPS:       99,
+		MinQPS:    95,
+		MedianQPS: 99,
+		MaxQPS:    101.5,
+		Response:  response,
+		Token:     "token",
+		Errors:    5,
+		Timeouts:  5,
+		ErrorsByClass: map[string]*ErrorStat{
+			"mysql:1062": {Count: 5, Sample: "Duplicate entry '1' for key 'PRIMARY' | x"},
+		},
+		Assertions: []*AssertionResult{
+			{Assertion: "p99<20ms", Actual: "5ms", Passed: true},
+			{Assertion: "errors<0.1%", Actual: "0.5%", Passed: false},
+		},
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	report := testReport()
+	report.calcErrorRate()
+
+	tests := []struct {
+		header   bool
+		expected string
+	}{
+		{
+			true,
+			"started,elapsed_sec,nagents,max_agents,queries,qps,min_qps,median_qps,max_qps,errors,error_rate,timeouts,min_ms,p50_ms,p75_ms,p95_ms,p99_ms,p999_ms,max_ms,avg_ms,interrupted,assertions_passed,token\n" +
+				"2021-01-02T03:04:05Z,10,4,4,990,99.00,95.00,99.00,101.50,5,0.005,5,0.500,1.000,1.500,3.000,5.000,8.000,12.000,1.250,false,false,token\n",
+		},
+		{
+			false,
+			"2021-01-02T03:04:05Z,10,4,4,990,99.00,95.00,99.00,101.50,5,0.005,5,0.500,1.000,1.500,3.000,5.000,8.000,12.000,1.250,false,false,token\n",
+		},
+	}
+
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		err := report.WriteReport(&buf, ReportFormatCSV, tt.header)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if buf.String() != tt.expected {
+			t.Errorf("expected:\n%s\ngot:\n%s", tt.expected, buf.String())
+		}
+	}
+}
+
+func TestWriteMarkdown(t *testing.T) {
+	report := testReport()
+	report.calcErrorRate()
+	var buf bytes.Buffer
+	err := report.WriteReport(&buf, ReportFormatMarkdown, false)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	md := buf.String()
+
+	for _, expected := range []string{
+		"## qrn report\n",
+		"| Status | completed |\n",
+		"| Started | 2021-01-02T03:04:05Z |\n",
+		"| Agents | 4 (max 4) |\n",
+		"| QPS | 99.0 (min 95.0 / median 99.0 / max 101.5) |\n",
+		"| Errors | 5 (0.500%) |\n",
+		"| Timeouts | 5 (0.500%) |\n",
+		"| 0.500 | 1.000 | 1.500 | 3.000 | 5.000 | 8.000 | 12.000 | 1.250 |\n",
+		"| mysql:1062 | 5 | Duplicate entry '1' for key 'PRIMARY' \\| x |\n",
+	} {
+		if !strings.Contains(md, expected) {
+			t.Errorf("expected %q in:\n%s", expected, md)
+		}
+	}
+
+	if strings.Contains(md, "Downtime") {
+		t.Errorf("unexpected downtime in:\n%s", md)
+	}
+}
+
+func TestWriteJUnit(t *testing.T) {
+	tests := []struct {
+		name     string
+		modify   func(*RecordReport)
+		expected []string
+	}{
+		{
+			"completed",
+			func(*RecordReport) {},
+			[]string{
+				`<testsuite name="qrn" tests="3" failures="1" time="10" timestamp="2021-01-02T03:04:05">`,
+				`<property name="errors" value="5"></property>`,
+				`<testcase name="run" classname="qrn" time="10"></testcase>`,
+				`<testcase name="p99&lt;20ms" classname="qrn.assertions" time="0"></testcase>`,
+				`<failure message="errors&lt;0.1% (actual 0.5%)" type="assertion">expected errors&lt;0.1%, actual 0.5%</failure>`,
+			},
+		},
+		{
+			"interrupted",
+			func(r *RecordReport) { r.Interrupted = true; r.Assertions = nil },
+			[]string{
+				`<testsuite name="qrn" tests="1" failures="1" time="10" timestamp="2021-01-02T03:04:05">`,
+				`<failure message="interrupted" type="interrupted"></failure>`,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report := testReport()
+			tt.modify(report)
+			var buf bytes.Buffer
+			err := report.WriteReport(&buf, ReportFormatJUnit, false)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			xml := buf.String()
+
+			if !strings.HasPrefix(xml, `<?xml version="1.0" encoding="UTF-8"?>`) {
+				t.Errorf("expected the XML header, got:\n%s", xml)
+			}
+
+			for _, expected := range tt.expected {
+				if !strings.Contains(xml, expected) {
+					t.Errorf("expected %q in:\n%s", expected, xml)
+				}
+			}
+		})
+	}
+}
+
+func TestWriteReportUnknownFormat(t *testing.T) {
+	var buf bytes.Buffer
+
+	if err := testReport().WriteReport(&buf, "yaml", false); err == nil {
+		t.Error("expected an error")
+	}
+}
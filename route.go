@@ -0,0 +1,144 @@
+package qrn
+
+import (
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/winebarrel/tachymeter"
+)
+
+const (
+	RoutePrimary = "primary"
+	RouteReplica = "replica"
+)
+
+type RouteReport struct {
+	DSNs      []string
+	Queries   int
+	QPS       float64
+	Errors    int
+	ErrorRate float64
+	Response  *tachymeter.Metrics
+}
+
+// writeFunctions are the functions that change sequences or take locks, which are not replicated,
+// and those that read their state in the session on the primary.
+var writeFunctions = map[string]bool{
+	"NEXTVAL":           true,
+	"SETVAL":            true,
+	"CURRVAL":           true,
+	"LASTVAL":           true,
+	"GET_LOCK":          true,
+	"RELEASE_LOCK":      true,
+	"RELEASE_ALL_LOCKS": true,
+	"IS_FREE_LOCK":      true,
+	"IS_USED_LOCK":      true,
+}
+
+// IsReadOnly returns true if the query can be executed on a read replica.
+// Locking reads (e.g. "SELECT ... FOR UPDATE"), "SELECT ... INTO" and calls of sequence and lock functions
+// (e.g. "SELECT nextval('seq')", "SELECT GET_LOCK('name', 10)") are not read-only.
+func IsReadOnly(query string) bool {
+	query = trimStatementHead(query)
+
+	switch {
+	case hasPrefixFold(query, "SHOW"), hasPrefixFold(query, "DESC"), hasPrefixFold(query, "EXPLAIN"):
+		return true
+	case hasPrefixFold(query, "SELECT"), hasPrefixFold(query, "WITH"):
+		// nothing to do
+	default:
+		return false
+	}
+
+	// split at parentheses too, e.g. "WITH x AS (DELETE ..."
+	words := strings.FieldsFunc(strings.ToUpper(query), func(r rune) bool {
+		return unicode.IsSpace(r) || r == '(' || r == ')' || r == ',' || r == ';'
+	})
+
+	for i, w := range words {
+		switch w {
+		case "INSERT", "UPDATE", "DELETE", "REPLACE", "MERGE", "INTO":
+			return false
+		case "FOR":
+			if i+1 < len(words) && (words[i+1] == "UPDATE" || words[i+1] == "SHARE" || words[i+1] == "NO") {
+				return false
+			}
+		case "LOCK":
+			if i+2 < len(words) && words[i+1] == "IN" && words[i+2] == "SHARE" {
+				return false
+			}
+		case "NEXT":
+			// "NEXT VALUE FOR seq" of MariaDB
+			if i+2 < len(words) && words[i+1] == "VALUE" && words[i+2] == "FOR" {
+				return false
+			}
+		default:
+			// without the schema, e.g. "pg_catalog.nextval"
+			name := w[strings.LastIndexByte(w, '.')+1:]
+
+			if writeFunctions[name] || strings.HasPrefix(name, "PG_ADVISORY_") || strings.HasPrefix(name, "PG_TRY_ADVISORY_") {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// trimStatementHead skips leading spaces, comments and parentheses.
+func trimStatementHead(query string) string {
+	for {
+		query = strings.TrimLeft(query, " \t\r\n(")
+
+		if strings.HasPrefix(query, "/*") {
+			if i := strings.Index(query, "*/"); i >= 0 {
+				query = query[i+2:]
+				continue
+			}
+		} else if strings.HasPrefix(query, "--") || strings.HasPrefix(query, "#") {
+			if i := strings.IndexByte(query, '\n'); i >= 0 {
+				query = query[i+1:]
+				continue
+			}
+		}
+
+		return query
+	}
+}
+
+func (recorder *Recorder) routeReports() map[string]*RouteReport {
+	nanoElapsed := recorder.Finished.Sub(recorder.Started)
+	responseTimes := map[string][]DataPoint{}
+
+	reports := map[string]*RouteReport{
+		RoutePrimary: {DSNs: []string{}},
+		RouteReplica: {DSNs: recorder.Replicas},
+	}
+
+	for _, t := range recorder.Targets {
+		reports[RoutePrimary].DSNs = append(reports[RoutePrimary].DSNs, t.ConnInfo.DSN)
+	}
+
+	for _, v := range recorder.ResponseTimes {
+		responseTimes[v.Route] = append(responseTimes[v.Route], v)
+	}
+
+	for _, v := range recorder.ErrorPoints {
+		if r, ok := reports[v.Route]; ok {
+			r.Errors++
+		}
+	}
+
+	for route, r := range reports {
+		r.Queries = len(responseTimes[route])
+		r.QPS = float64(r.Queries) * float64(time.Second) / float64(nanoElapsed)
+		r.Response = recorder.calcMetrics(responseTimes[route])
+
+		if total := r.Queries + r.Errors; total > 0 {
+			r.ErrorRate = float64(r.Errors) / float64(total)
+		}
+	}
+
+	return reports
+}
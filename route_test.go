@@ -0,0 +1,79 @@
+package qrn
+
+import (
+	"testing"
+)
+
+func TestIsReadOnly(t *testing.T) {
+	tests := []struct {
+		query    string
+		expected bool
+	}{
+		{"SELECT * FROM t", true},
+		{"  select 1", true},
+		{"(SELECT 1) UNION (SELECT 2)", true},
+		{"/* comment */ SELECT 1", true},
+		{"-- comment\nSELECT 1", true},
+		{"WITH x AS (SELECT 1) SELECT * FROM x", true},
+		{"SHOW TABLES", true},
+		{"DESC t", true},
+		{"EXPLAIN SELECT 1", true},
+		{"SELECT * FROM t WHERE note = 'insert'", true},
+		{"SELECT * FROM t FOR UPDATE", false},
+		{"select * from t for share", false},
+		{"SELECT * FROM t FOR NO KEY UPDATE", false},
+		{"SELECT * FROM t LOCK IN SHARE MODE", false},
+		{"SELECT 1 INTO @x", false},
+		{"WITH x AS (DELETE FROM t RETURNING *) SELECT * FROM x", false},
+		{"SELECT nextval('seq')", false},
+		{"SELECT pg_catalog.nextval('seq'::regclass)", false},
+		{"SELECT setval('seq', 42)", false},
+		{"SELECT NEXT VALUE FOR seq", false},
+		{"SELECT GET_LOCK('name', 10)", false},
+		{"SELECT RELEASE_LOCK('name')", false},
+		{"SELECT pg_advisory_lock(1)", false},
+		{"SELECT pg_try_advisory_xact_lock(1)", false},
+		{"SELECT pg_advisory_unlock_all()", false},
+		{"SELECT currval('seq')", false},
+		{"SELECT nextval_count FROM t", true},
+		{"INSERT INTO t VALUES (1)", false},
+		{"UPDATE t SET c = 1", false},
+		{"DELETE FROM t", false},
+		{"BEGIN", false},
+		{"SET @x = 1", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		if ok := IsReadOnly(tt.query); ok != tt.expected {
+			t.Errorf("IsReadOnly(%q): expected %v, got %v", tt.query, tt.expected, ok)
+		}
+	}
+}
+
+func TestAgentRoute(t *testing.T) {
+	primary := &Session{}
+	replica := &Session{}
+	agent := &Agent{Session: primary, Replica: replica}
+
+	tests := []struct {
+		query   string
+		session *Session
+		route   string
+	}{
+		{"SELECT 1", replica, RouteReplica},
+		{"UPDATE t SET c = 1", primary, RoutePrimary},
+		{"BEGIN", primary, RoutePrimary},
+		{"SELECT 1", primary, RoutePrimary},
+		{"COMMIT", primary, RoutePrimary},
+		{"SELECT 1", replica, RouteReplica},
+	}
+
+	for i, tt := range tests {
+		session, route := agent.route(tt.query)
+
+		if session != tt.session || route != tt.route {
+			t.Errorf("%d: %s: expected %s, got %s", i, tt.query, tt.route, route)
+		}
+	}
+}
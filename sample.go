@@ -0,0 +1,460 @@
+package qrn
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// SampleMagic is the first bytes of a sample dump (after decompression).
+const SampleMagic = "qrn-samples\x01"
+
+// Records of a sample dump. A string is defined once and referenced by its index (1-based, 0 is empty).
+// A sample is encoded with varints, and its time is the difference from the previous sample.
+// The run metadata is written at the end, so a dump of an aborted process can be read without it.
+const (
+	sampleRecordString = 's'
+	sampleRecordPoint  = 'p'
+	sampleRecordMeta   = 'm'
+)
+
+const sampleFlagMismatch = 1
+
+type sampleTarget struct {
+	DSN     string
+	NAgents int
+}
+
+type sampleMeta struct {
+	Token        string
+	DSN          string
+	Files        []string
+	PreQueries   []string
+	Started      time.Time
+	Finished     time.Time
+	NAgents      int
+	Rate         int
+	LoopCount    int64
+	Targets      []sampleTarget
+	Replicas     []string
+	Compare      bool
+	Shadow       string
+	Concurrency  []ConcurrencyPoint
+	Annotations  []Annotation
+	ErrorSamples map[string]string
+	ConnectTimes []time.Duration
+	Outages      []*Outage
+	Aborted      bool
+	Interrupted  bool
+}
+
+// SampleWriter writes every data point to a gzip-compressed binary file, which can be re-analyzed with ReplaySamples.
+type SampleWriter struct {
+	sync.Mutex
+	gz      *gzip.Writer
+	out     *bufio.Writer
+	strings map[string]uint64
+	prev    int64
+	buf     []byte
+	err     error
+}
+
+func NewSampleWriter(w io.Writer) *SampleWriter {
+	gz := gzip.NewWriter(w)
+	sw := &SampleWriter{
+		gz:      gz,
+		out:     bufio.NewWriter(gz),
+		strings: map[string]uint64{"": 0},
+		buf:     make([]byte, binary.MaxVarintLen64),
+	}
+
+	_, sw.err = sw.out.WriteString(SampleMagic)
+
+	return sw
+}
+
+func (sw *SampleWriter) uvarint(v uint64) {
+	n := binary.PutUvarint(sw.buf, v)
+	sw.out.Write(sw.buf[:n])
+}
+
+func (sw *SampleWriter) varint(v int64) {
+	n := binary.PutVarint(sw.buf, v)
+	sw.out.Write(sw.buf[:n])
+}
+
+func (sw *SampleWriter) stringRef(s string) uint64 {
+	if id, ok := sw.strings[s]; ok {
+		return id
+	}
+
+	id := uint64(len(sw.strings))
+	sw.strings[s] = id
+	sw.out.WriteByte(sampleRecordString)
+	sw.uvarint(uint64(len(s)))
+	sw.out.WriteString(s)
+
+	return id
+}
+
+func (sw *SampleWriter) Observe(responseTimes []DataPoint) {
+	sw.Lock()
+	defer sw.Unlock()
+
+	if sw.err != nil {
+		return
+	}
+
+	for _, v := range responseTimes {
+		fingerprint := sw.stringRef(v.Fingerprint)
+		class := sw.stringRef(v.Error)
+		route := sw.stringRef(v.Route)
+		tm := v.Time.UnixNano()
+		var flags uint64
+
+		if v.Mismatch {
+			flags |= sampleFlagMismatch
+		}
+
+		sw.out.WriteByte(sampleRecordPoint)
+		sw.varint(tm - sw.prev)
+		sw.uvarint(uint64(v.ResponseTime))
+		sw.uvarint(uint64(v.Agent))
+		sw.uvarint(uint64(v.Target))
+		sw.uvarint(fingerprint)
+		sw.uvarint(class)
+		sw.uvarint(route)
+		sw.varint(v.Rows)
+		sw.uvarint(flags)
+		sw.prev = tm
+	}
+
+	// flush each batch so that the dump is readable even if the process is killed
+	sw.err = sw.out.Flush()
+
+	if sw.err == nil {
+		sw.err = sw.gz.Flush()
+	}
+}
+
+// Close writes the metadata of the finished run and flushes the dump. It returns the first write error.
+func (sw *SampleWriter) Close(recorder *Recorder) error {
+	sw.Lock()
+	defer sw.Unlock()
+
+	if sw.err != nil {
+		return sw.err
+	}
+
+	recorder.Lock()
+
+	meta := &sampleMeta{
+		Token:        recorder.Token,
+		DSN:          recorder.DSN,
+		Files:        recorder.Files,
+		PreQueries:   recorder.PreQueris,
+		Started:      recorder.Started,
+		Finished:     recorder.Finished,
+		NAgents:      recorder.NAgents,
+		Rate:         recorder.Rate,
+		LoopCount:    recorder.LoopCount,
+		Replicas:     recorder.Replicas,
+		Compare:      recorder.Compare,
+		Shadow:       recorder.Shadow,
+		Concurrency:  recorder.Concurrency,
+		Annotations:  recorder.Annotations,
+		ErrorSamples: recorder.ErrorSamples,
+		ConnectTimes: recorder.ConnectTimes,
+		Outages:      recorder.Outages,
+		Aborted:      recorder.Aborted,
+		Interrupted:  recorder.Interrupted,
+	}
+
+	for _, t := range recorder.Targets {
+		meta.Targets = append(meta.Targets, sampleTarget{DSN: t.ConnInfo.DSN, NAgents: t.NAgents})
+	}
+
+	recorder.Unlock()
+
+	raw, err := json.Marshal(meta)
+
+	if err != nil {
+		return err
+	}
+
+	sw.out.WriteByte(sampleRecordMeta)
+	sw.uvarint(uint64(len(raw)))
+	sw.out.Write(raw)
+
+	err = sw.out.Flush()
+
+	if err != nil {
+		return err
+	}
+
+	return sw.gz.Close()
+}
+
+// ReplayOptions are the settings of the re-analysis of a sample dump.
+// From and To restrict the samples to a window from the start of the run (zero is unlimited).
+type ReplayOptions struct {
+	HBins         int
+	HInterval     time.Duration
+	ClampTimeouts bool
+	From          time.Duration
+	To            time.Duration
+	Assertions    Assertions
+	TimeSeries    *TimeSeries
+	HistogramLog  *HistogramLog
+}
+
+type sampleReader struct {
+	*bufio.Reader
+	strings []string
+}
+
+func (sr *sampleReader) stringAt(id uint64) (string, error) {
+	if id >= uint64(len(sr.strings)) {
+		return "", fmt.Errorf("undefined string: %d", id)
+	}
+
+	return sr.strings[id], nil
+}
+
+func (sr *sampleReader) readBytes() ([]byte, error) {
+	n, err := binary.ReadUvarint(sr)
+
+	if err != nil {
+		return nil, err
+	}
+
+	b := make([]byte, n)
+	_, err = io.ReadFull(sr, b)
+
+	return b, err
+}
+
+func (sr *sampleReader) readPoint(prev int64) (DataPoint, int64, error) {
+	v := DataPoint{}
+	var fields [7]uint64
+	delta, err := binary.ReadVarint(sr)
+
+	if err != nil {
+		return v, prev, err
+	}
+
+	for i := range fields[:6] {
+		fields[i], err = binary.ReadUvarint(sr)
+
+		if err != nil {
+			return v, prev, err
+		}
+	}
+
+	v.Rows, err = binary.ReadVarint(sr)
+
+	if err != nil {
+		return v, prev, err
+	}
+
+	fields[6], err = binary.ReadUvarint(sr)
+
+	if err != nil {
+		return v, prev, err
+	}
+
+	tm := prev + delta
+	v.Time = time.Unix(0, tm)
+	v.ResponseTime = time.Duration(fields[0])
+	v.Agent = int(fields[1])
+	v.Target = int(fields[2])
+	v.Mismatch = fields[6]&sampleFlagMismatch != 0
+
+	for i, s := range []*string{&v.Fingerprint, &v.Error, &v.Route} {
+		*s, err = sr.stringAt(fields[3+i])
+
+		if err != nil {
+			return v, prev, err
+		}
+	}
+
+	return v, tm, nil
+}
+
+// ReplaySamples reads a sample dump and returns the recorder of the run as if it had just finished.
+// A dump truncated by a killed process is read up to its last complete sample.
+func ReplaySamples(path string, options *ReplayOptions) (*Recorder, error) {
+	file, err := os.Open(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+	gz, err := gzip.NewReader(file)
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid sample dump: %s: %w", path, err)
+	}
+
+	sr := &sampleReader{Reader: bufio.NewReader(gz), strings: []string{""}}
+	magic := make([]byte, len(SampleMagic))
+	_, err = io.ReadFull(sr, magic)
+
+	if err != nil || string(magic) != SampleMagic {
+		return nil, fmt.Errorf("invalid sample dump: %s", path)
+	}
+
+	points := []DataPoint{}
+	var meta *sampleMeta
+	var prev int64
+
+	for {
+		kind, err := sr.ReadByte()
+
+		if err != nil {
+			if err == io.EOF || errors.Is(err, io.ErrUnexpectedEOF) {
+				break
+			}
+
+			return nil, fmt.Errorf("invalid sample dump: %s: %w", path, err)
+		}
+
+		switch kind {
+		case sampleRecordString:
+			var b []byte
+			b, err = sr.readBytes()
+
+			if err == nil {
+				sr.strings = append(sr.strings, string(b))
+			}
+		case sampleRecordPoint:
+			var v DataPoint
+			v, prev, err = sr.readPoint(prev)
+
+			if err == nil {
+				points = append(points, v)
+			}
+		case sampleRecordMeta:
+			var b []byte
+			b, err = sr.readBytes()
+
+			if err == nil {
+				meta = &sampleMeta{}
+				err = json.Unmarshal(b, meta)
+			}
+		default:
+			err = fmt.Errorf("unknown record: %q", kind)
+		}
+
+		if errors.Is(err, io.ErrUnexpectedEOF) || err == io.EOF {
+			// truncated
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("invalid sample dump: %s: %w", path, err)
+		}
+	}
+
+	if meta == nil {
+		// the process was killed before the end of the run
+		meta = &sampleMeta{Interrupted: true}
+		agents := map[int]bool{}
+
+		for i, v := range points {
+			agents[v.Agent] = true
+
+			if i == 0 || v.Time.Before(meta.Started) {
+				meta.Started = v.Time
+			}
+
+			if v.Time.After(meta.Finished) {
+				meta.Finished = v.Time
+			}
+		}
+
+		meta.NAgents = len(agents)
+	}
+
+	return meta.replay(points, options), nil
+}
+
+func (meta *sampleMeta) replay(points []DataPoint, options *ReplayOptions) *Recorder {
+	recorder := &Recorder{
+		DSN:           meta.DSN,
+		Files:         meta.Files,
+		PreQueris:     meta.PreQueries,
+		Replicas:      meta.Replicas,
+		Compare:       meta.Compare,
+		Shadow:        meta.Shadow,
+		Started:       meta.Started,
+		NAgents:       meta.NAgents,
+		Rate:          meta.Rate,
+		LoopCount:     meta.LoopCount,
+		HBins:         options.HBins,
+		HInterval:     options.HInterval,
+		ClampTimeouts: options.ClampTimeouts,
+		Token:         meta.Token,
+		Concurrency:   meta.Concurrency,
+		Annotations:   meta.Annotations,
+		ResponseTimes: []DataPoint{},
+		ErrorPoints:   []DataPoint{},
+		ShadowPoints:  []DataPoint{},
+		ErrorSamples:  meta.ErrorSamples,
+		ConnectTimes:  meta.ConnectTimes,
+		Outages:       meta.Outages,
+		Aborted:       meta.Aborted,
+		Interrupted:   meta.Interrupted,
+		Assertions:    options.Assertions,
+	}
+
+	if recorder.ErrorSamples == nil {
+		recorder.ErrorSamples = map[string]string{}
+	}
+
+	for i, t := range meta.Targets {
+		recorder.Targets = append(recorder.Targets, &Target{Index: i, ConnInfo: &ConnInfo{DSN: t.DSN}, NAgents: t.NAgents})
+	}
+
+	finished := meta.Finished
+
+	if options.From > 0 {
+		recorder.Started = meta.Started.Add(options.From)
+	}
+
+	if options.To > 0 && meta.Started.Add(options.To).Before(finished) {
+		finished = meta.Started.Add(options.To)
+	}
+
+	sort.Slice(points, func(i, j int) bool { return points[i].Time.Before(points[j].Time) })
+	window := []DataPoint{}
+
+	for _, v := range points {
+		if !v.Time.Before(recorder.Started) && !v.Time.After(finished) {
+			window = append(window, v)
+		}
+	}
+
+	recorder.AppendResponseTimes(window)
+
+	if options.TimeSeries != nil {
+		recorder.TimeSeries = options.TimeSeries
+		options.TimeSeries.begin(recorder)
+	}
+
+	if options.HistogramLog != nil {
+		recorder.HistogramLog = options.HistogramLog
+		options.HistogramLog.begin(recorder)
+	}
+
+	recorder.finish(finished)
+
+	return recorder
+}
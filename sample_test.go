@@ -0,0 +1,239 @@
+package qrn
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func samplePoints(started time.Time) [][]DataPoint {
+	batches := [][]DataPoint{}
+
+	for i := 0; i < 3; i++ {
+		batch := []DataPoint{}
+
+		for j := 0; j < 100; j++ {
+			v := DataPoint{
+				Time:         started.Add(time.Duration(i*100+j) * time.Millisecond),
+				ResponseTime: time.Duration(j+1) * time.Millisecond,
+				Fingerprint:  "select * from t where id = ?",
+				Agent:        j % 4,
+				Rows:         int64(j),
+			}
+
+			if j%10 == 0 {
+				v.Error = ErrorClassTimeout
+				v.Rows = 0
+			} else if j%25 == 0 {
+				v.Route = RouteReplica
+				v.Fingerprint = "select 1"
+			}
+
+			batch = append(batch, v)
+		}
+
+		batches = append(batches, batch)
+	}
+
+	return batches
+}
+
+func writeSamples(t *testing.T, batches [][]DataPoint, recorder *Recorder) []byte {
+	var buf bytes.Buffer
+	sw := NewSampleWriter(&buf)
+
+	for _, batch := range batches {
+		sw.Observe(batch)
+	}
+
+	if recorder != nil {
+		err := sw.Close(recorder)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return buf.Bytes()
+}
+
+func replaySamples(t *testing.T, dump []byte) *Recorder {
+	path := filepath.Join(t.TempDir(), "run.qrns")
+	err := ioutil.WriteFile(path, dump, 0644)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder, err := ReplaySamples(path, &ReplayOptions{HBins: 10})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return recorder
+}
+
+// replayedPoints returns the points of the recorder by their time from the start of the run.
+func replayedPoints(recorder *Recorder) map[time.Duration]DataPoint {
+	points := map[time.Duration]DataPoint{}
+
+	for _, list := range [][]DataPoint{recorder.ResponseTimes, recorder.ErrorPoints} {
+		for _, v := range list {
+			points[v.Time.Sub(recorder.Started)] = v
+		}
+	}
+
+	return points
+}
+
+func assertPoint(t *testing.T, expected DataPoint, actual DataPoint) {
+	t.Helper()
+
+	if !actual.Time.Equal(expected.Time) {
+		t.Errorf("expected time %s, got %s", expected.Time, actual.Time)
+	}
+
+	expected.Time = time.Time{}
+	actual.Time = time.Time{}
+
+	if actual != expected {
+		t.Errorf("expected %+v, got %+v", expected, actual)
+	}
+}
+
+func TestSampleRoundTrip(t *testing.T) {
+	started := time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC)
+	batches := samplePoints(started)
+
+	recorder := &Recorder{
+		DSN:          "root@tcp(127.0.0.1:3306)/",
+		Files:        []string{"data.jsonl"},
+		Started:      started,
+		Finished:     started.Add(time.Second),
+		NAgents:      4,
+		Rate:         100,
+		LoopCount:    2,
+		Token:        "token",
+		Targets:      []*Target{{ConnInfo: &ConnInfo{DSN: "root@tcp(127.0.0.1:3306)/"}, NAgents: 4}},
+		Replicas:     []string{"root@tcp(127.0.0.2:3306)/"},
+		ErrorSamples: map[string]string{ErrorClassTimeout: "context deadline exceeded"},
+		Annotations:  []Annotation{{Time: started.Add(500 * time.Millisecond), Text: "deploy"}},
+		Interrupted:  true,
+	}
+
+	replayed := replaySamples(t, writeSamples(t, batches, recorder))
+
+	if replayed.Token != recorder.Token || replayed.DSN != recorder.DSN || replayed.NAgents != recorder.NAgents || replayed.Rate != recorder.Rate || replayed.LoopCount != recorder.LoopCount {
+		t.Errorf("metadata mismatch: %+v", replayed)
+	}
+
+	if !replayed.Started.Equal(recorder.Started) || !replayed.Finished.Equal(recorder.Finished) {
+		t.Errorf("expected %s-%s, got %s-%s", recorder.Started, recorder.Finished, replayed.Started, replayed.Finished)
+	}
+
+	if len(replayed.Targets) != 1 || replayed.Targets[0].ConnInfo.DSN != recorder.Targets[0].ConnInfo.DSN || replayed.Targets[0].NAgents != 4 {
+		t.Errorf("targets mismatch: %+v", replayed.Targets)
+	}
+
+	if len(replayed.Replicas) != 1 || replayed.Replicas[0] != recorder.Replicas[0] {
+		t.Errorf("replicas mismatch: %v", replayed.Replicas)
+	}
+
+	if replayed.ErrorSamples[ErrorClassTimeout] != "context deadline exceeded" {
+		t.Errorf("error samples mismatch: %v", replayed.ErrorSamples)
+	}
+
+	if len(replayed.Annotations) != 1 || replayed.Annotations[0].Text != "deploy" {
+		t.Errorf("annotations mismatch: %v", replayed.Annotations)
+	}
+
+	if !replayed.Interrupted || replayed.Aborted {
+		t.Errorf("expected interrupted, got interrupted=%v aborted=%v", replayed.Interrupted, replayed.Aborted)
+	}
+
+	points := replayedPoints(replayed)
+	n := 0
+
+	for _, batch := range batches {
+		for _, expected := range batch {
+			n++
+			actual, ok := points[expected.Time.Sub(started)]
+
+			if !ok {
+				t.Errorf("missing point at %s", expected.Time)
+				continue
+			}
+
+			assertPoint(t, expected, actual)
+		}
+	}
+
+	if len(points) != n {
+		t.Errorf("expected %d points, got %d", n, len(points))
+	}
+
+	if len(replayed.ErrorPoints) != 30 {
+		t.Errorf("expected 30 error points, got %d", len(replayed.ErrorPoints))
+	}
+}
+
+func TestSampleTruncated(t *testing.T) {
+	started := time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC)
+	batches := samplePoints(started)
+
+	full := writeSamples(t, batches, nil)
+	head := writeSamples(t, batches[:2], nil)
+
+	tests := []struct {
+		name   string
+		dump   []byte
+		points int
+	}{
+		// the process was killed after the last batch was flushed
+		{"unclosed", full, 300},
+		// the file was cut in the middle of the last batch
+		{"truncated", full[:len(head)+(len(full)-len(head))/2], 200},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			replayed := replaySamples(t, tt.dump)
+			points := replayedPoints(replayed)
+
+			if len(points) < tt.points || len(points) > 300 {
+				t.Fatalf("expected %d-300 points, got %d", tt.points, len(points))
+			}
+
+			for i, batch := range batches {
+				for j, expected := range batch {
+					actual, ok := points[expected.Time.Sub(started)]
+
+					if !ok {
+						if i*100+j < tt.points {
+							t.Errorf("missing point at %s", expected.Time)
+						}
+
+						continue
+					}
+
+					assertPoint(t, expected, actual)
+				}
+			}
+
+			if !replayed.Interrupted {
+				t.Error("expected interrupted")
+			}
+
+			if !replayed.Started.Equal(started) {
+				t.Errorf("expected started %s, got %s", started, replayed.Started)
+			}
+
+			if replayed.NAgents != 4 {
+				t.Errorf("expected 4 agents, got %d", replayed.NAgents)
+			}
+		})
+	}
+}
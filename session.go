@@ -0,0 +1,224 @@
+package qrn
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+const (
+	// ConnModePersistent keeps the connection of each agent during the run
+	ConnModePersistent = "persistent"
+	// ConnModeQuery opens a new connection for each query
+	ConnModeQuery = "query"
+	// ConnModeTx opens a new connection for each transaction (or each query outside transactions)
+	ConnModeTx = "tx"
+	// ConnModeLifetime reopens the connection after a random lifetime
+	ConnModeLifetime = "lifetime"
+)
+
+type ConnInfo struct {
+	Driver       string
+	DSN          string
+	Host         string
+	MaxIdleConns int
+	Mode         string
+	Lifetime     Distribution
+}
+
+// Session is a dedicated connection of an agent to a database.
+type Session struct {
+	ConnInfo     *ConnInfo
+	DB           *sql.DB
+	Conn         *sql.Conn
+	PreQueries   []string
+	recorder     *Recorder
+	connectTimes []time.Duration
+	inTx         bool
+	expires      time.Time
+}
+
+func (session *Session) Open(preQueries []string) error {
+	db, err := sql.Open(session.ConnInfo.Driver, session.ConnInfo.DSN)
+
+	if err != nil {
+		return err
+	}
+
+	db.SetConnMaxLifetime(0)
+	db.SetMaxIdleConns(0)
+
+	err = db.Ping()
+
+	if err != nil {
+		return err
+	}
+
+	if session.churn() {
+		// released connections must be closed to establish a new one
+		db.SetMaxIdleConns(0)
+	} else {
+		db.SetMaxIdleConns(session.ConnInfo.MaxIdleConns)
+	}
+
+	session.DB = db
+	session.PreQueries = preQueries
+
+	return session.connect(context.Background())
+}
+
+func (session *Session) setRecorder(recorder *Recorder) {
+	session.recorder = recorder
+
+	for _, v := range session.connectTimes {
+		recorder.AddConnectTime(v)
+	}
+
+	session.connectTimes = nil
+}
+
+// connect pins a dedicated connection to the session and runs the pre-queries on it,
+// so that session settings (e.g. "SET sql_mode") apply to all queries of the agent.
+func (session *Session) connect(ctx context.Context) error {
+	start := time.Now()
+	conn, err := session.DB.Conn(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	if session.recorder != nil {
+		session.recorder.AddConnectTime(time.Since(start))
+	} else {
+		// connected by Open before Run
+		session.connectTimes = append(session.connectTimes, time.Since(start))
+	}
+
+	if session.ConnInfo.Mode == ConnModeLifetime {
+		session.expires = time.Now().Add(session.ConnInfo.Lifetime.Next())
+	}
+
+	for _, q := range session.PreQueries {
+		_, err = conn.ExecContext(ctx, q)
+
+		if err != nil {
+			conn.Close()
+			return err
+		}
+	}
+
+	session.Conn = conn
+
+	return nil
+}
+
+func (session *Session) churn() bool {
+	return session.ConnInfo.Mode != "" && session.ConnInfo.Mode != ConnModePersistent
+}
+
+// release closes the connection according to the connection mode after a query.
+func (session *Session) release(query string) {
+	switch session.ConnInfo.Mode {
+	case ConnModeQuery:
+		session.disconnect()
+	case ConnModeTx:
+		if isTxBegin(query) {
+			session.inTx = true
+		} else if isTxEnd(query) {
+			session.inTx = false
+		}
+
+		if !session.inTx {
+			session.disconnect()
+		}
+	case ConnModeLifetime:
+		if time.Now().After(session.expires) {
+			session.disconnect()
+		}
+	}
+}
+
+func (session *Session) disconnect() {
+	if session.Conn == nil {
+		return
+	}
+
+	session.Conn.Close()
+	session.Conn = nil
+}
+
+// Exec executes the query and returns the number of affected rows.
+func (session *Session) Exec(ctx context.Context, query string, timeout time.Duration) (time.Duration, int64, error) {
+	var rows int64
+
+	rt, err := session.do(ctx, query, timeout, func(ctx context.Context) error {
+		result, err := session.Conn.ExecContext(ctx, query)
+
+		if err != nil {
+			return err
+		}
+
+		// some drivers do not support it
+		rows, _ = result.RowsAffected()
+		return nil
+	})
+
+	return rt, rows, err
+}
+
+// Fetch executes the query and reads the result set. The response time includes reading the rows.
+func (session *Session) Fetch(ctx context.Context, query string, timeout time.Duration) (time.Duration, *ResultSet, error) {
+	var rs *ResultSet
+
+	rt, err := session.do(ctx, query, timeout, func(ctx context.Context) error {
+		rows, err := session.Conn.QueryContext(ctx, query)
+
+		if err != nil {
+			return err
+		}
+
+		rs, err = readResultSet(rows)
+		return err
+	})
+
+	return rt, rs, err
+}
+
+func (session *Session) do(ctx context.Context, query string, timeout time.Duration, block func(context.Context) error) (time.Duration, error) {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	if session.Conn == nil {
+		err := session.connect(ctx)
+
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	start := time.Now()
+	err := block(ctx)
+	end := time.Now()
+
+	if err != nil && (IsConnectionError(err) || ClassifyError(err) == ErrorClassTimeout) {
+		// the connection may be broken, so the session is re-established at the next query
+		session.inTx = false
+		session.disconnect()
+	} else {
+		session.release(query)
+	}
+
+	return end.Sub(start), err
+}
+
+func (session *Session) Close() {
+	if session.DB == nil {
+		return
+	}
+
+	session.disconnect()
+	session.DB.Close()
+}
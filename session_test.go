@@ -0,0 +1,222 @@
+package qrn
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const sessionDriverName = "fake-session"
+
+// sessionDriver is the fake driver recording which connection executes each query, keyed by the DSN.
+type sessionDriver struct {
+	sync.Mutex
+	stats map[string]*sessionStats
+}
+
+type sessionStats struct {
+	// Conns is the number of connections that executed queries other than the pre-query
+	Conns map[int]bool
+	// Unprepared is the number of queries executed on a connection without the pre-query
+	Unprepared int
+	opens      int
+}
+
+type sessionConn struct {
+	*fakeConn
+	driver   *sessionDriver
+	dsn      string
+	id       int
+	prepared bool
+}
+
+var testSessionDriver = &sessionDriver{stats: map[string]*sessionStats{}}
+
+func init() {
+	sql.Register(sessionDriverName, testSessionDriver)
+}
+
+func (d *sessionDriver) Open(dsn string) (driver.Conn, error) {
+	d.Lock()
+	defer d.Unlock()
+	stats, ok := d.stats[dsn]
+
+	if !ok {
+		stats = &sessionStats{Conns: map[int]bool{}}
+		d.stats[dsn] = stats
+	}
+
+	stats.opens++
+
+	return &sessionConn{fakeConn: &fakeConn{config: &FakeConfig{}}, driver: d, dsn: dsn, id: stats.opens}, nil
+}
+
+func (d *sessionDriver) Reset(dsn string) {
+	d.Lock()
+	defer d.Unlock()
+	delete(d.stats, dsn)
+}
+
+func (d *sessionDriver) Stats(dsn string) *sessionStats {
+	d.Lock()
+	defer d.Unlock()
+	return d.stats[dsn]
+}
+
+func (conn *sessionConn) record(query string) {
+	if strings.HasPrefix(query, "SET ") {
+		conn.prepared = true
+		return
+	}
+
+	if strings.Contains(query, "token=") {
+		// start/end markers of agents
+		return
+	}
+
+	d := conn.driver
+	d.Lock()
+	defer d.Unlock()
+	stats := d.stats[conn.dsn]
+	stats.Conns[conn.id] = true
+
+	if !conn.prepared {
+		stats.Unprepared++
+	}
+}
+
+func (conn *sessionConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	conn.record(query)
+	return conn.fakeConn.ExecContext(ctx, query, args)
+}
+
+func (conn *sessionConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	conn.record(query)
+	return conn.fakeConn.QueryContext(ctx, query, args)
+}
+
+func TestPinnedSession(t *testing.T) {
+	dsn := t.Name()
+	testSessionDriver.Reset(dsn)
+
+	options := &TaskOptions{
+		Driver:     sessionDriverName,
+		DSNs:       Strings{dsn},
+		Files:      Strings{testData(t, "select 1", "select 2", "select 3", "select 4", "select 5")},
+		NAgents:    2,
+		PreQueries: Strings{"SET search_path = test"},
+	}
+
+	_, report, err := runTask(t, options, 0, 10*time.Millisecond)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.Queries != 10 {
+		t.Errorf("expected 10 queries, got %d", report.Queries)
+	}
+
+	stats := testSessionDriver.Stats(dsn)
+
+	// each agent executes all queries on its own connection
+	if len(stats.Conns) != 2 {
+		t.Errorf("expected queries on 2 connections, got %d", len(stats.Conns))
+	}
+
+	if stats.Unprepared != 0 {
+		t.Errorf("expected the pre-query on every connection, got %d queries without it", stats.Unprepared)
+	}
+
+	if report.Connects != 2 {
+		t.Errorf("expected 2 connects, got %d", report.Connects)
+	}
+}
+
+func TestSessionReconnect(t *testing.T) {
+	dsn := t.Name()
+	testSessionDriver.Reset(dsn)
+	session := &Session{ConnInfo: &ConnInfo{Driver: sessionDriverName, DSN: dsn}}
+	err := session.Open([]string{"SET search_path = test"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer session.Close()
+
+	for i := 0; i < 2; i++ {
+		_, _, err = session.Exec(context.Background(), "select 1", 0)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// the connection is lost
+		session.disconnect()
+	}
+
+	stats := testSessionDriver.Stats(dsn)
+
+	if len(stats.Conns) != 2 {
+		t.Errorf("expected queries on 2 connections, got %d", len(stats.Conns))
+	}
+
+	if stats.Unprepared != 0 {
+		t.Errorf("expected the pre-query after reconnecting, got %d queries without it", stats.Unprepared)
+	}
+}
+
+func TestConnModes(t *testing.T) {
+	lifetime, _ := ParseDistribution("1h")
+	expired, _ := ParseDistribution("0s")
+
+	// the start marker, 4 queries and the end marker of an agent
+	tests := []struct {
+		mode     string
+		lifetime Distribution
+		expected int
+	}{
+		{ConnModePersistent, nil, 1},
+		{ConnModeQuery, nil, 6},
+		// the start marker, the transaction, "select 2" and the end marker
+		{ConnModeTx, nil, 4},
+		{ConnModeLifetime, lifetime, 1},
+		{ConnModeLifetime, expired, 6},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			dsn := t.Name()
+			testSessionDriver.Reset(dsn)
+
+			options := &TaskOptions{
+				Driver:       sessionDriverName,
+				DSNs:         Strings{dsn},
+				Files:        Strings{testData(t, "begin", "select 1", "commit", "select 2")},
+				NAgents:      1,
+				ConnMode:     tt.mode,
+				ConnLifetime: tt.lifetime,
+				PreQueries:   Strings{"SET search_path = test"},
+			}
+
+			_, report, err := runTask(t, options, 0, 10*time.Millisecond)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if report.Connects != tt.expected {
+				t.Errorf("expected %d connects, got %d", tt.expected, report.Connects)
+			}
+
+			if stats := testSessionDriver.Stats(dsn); stats.Unprepared != 0 {
+				t.Errorf("expected the pre-query on every new connection, got %d queries without it", stats.Unprepared)
+			}
+		})
+	}
+}
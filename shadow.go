@@ -0,0 +1,316 @@
+package qrn
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/winebarrel/tachymeter"
+)
+
+const RouteShadow = "shadow"
+
+const ShadowLogBufferSize = 1024
+
+// ResultSet is a summary of query results for comparison.
+// The checksums are order-insensitive, so that rows without "ORDER BY" can be compared.
+type ResultSet struct {
+	Columns         []string
+	Rows            int
+	Checksum        uint64
+	ColumnChecksums []uint64
+}
+
+type ShadowReport struct {
+	DSN               string
+	Queries           int
+	QPS               float64
+	Errors            int
+	ErrorRate         float64
+	Response          *tachymeter.Metrics
+	Mismatches        int
+	MismatchRate      float64
+	MismatchesByQuery map[string]int
+}
+
+type MismatchLog struct {
+	Query     string    `json:"query"`
+	Diff      string    `json:"diff"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// ShadowLogger writes the mismatches of shadow verification as JSON lines.
+// Mismatches are dropped instead of slowing down the agents when the output cannot keep up.
+type ShadowLogger struct {
+	Channel chan MismatchLog
+	Dropped int64
+	done    chan struct{}
+}
+
+// NewShadowLogger returns a logger writing to out. out is not closed by the logger.
+func NewShadowLogger(out io.Writer) *ShadowLogger {
+	ch := make(chan MismatchLog, ShadowLogBufferSize)
+
+	logger := &ShadowLogger{
+		Channel: ch,
+		done:    make(chan struct{}),
+	}
+
+	go func() {
+		for ml := range ch {
+			log, _ := jsoniter.MarshalToString(ml)
+			fmt.Fprintln(out, log)
+		}
+
+		close(logger.done)
+	}()
+
+	return logger
+}
+
+func (logger *ShadowLogger) Log(query string, diff string, ts time.Time) {
+	ml := MismatchLog{
+		Query:     query,
+		Diff:      diff,
+		Timestamp: ts,
+	}
+
+	select {
+	case logger.Channel <- ml:
+	default:
+		atomic.AddInt64(&logger.Dropped, 1)
+	}
+}
+
+func (logger *ShadowLogger) Close() {
+	close(logger.Channel)
+	<-logger.done
+}
+
+var numberRegexp = regexp.MustCompile(`^[+-]?(\d+\.?\d*|\.\d+)([eE][+-]?\d{1,3})?$`)
+
+var timeLayouts = []string{time.RFC3339Nano, "2006-01-02 15:04:05.999999999", "2006-01-02"}
+
+// canonicalValue returns the text form of a scanned value that does not depend on the driver.
+// Drivers return the same value as different types (e.g. MySQL returns numbers and times as []byte), so
+// numbers are converted into exact fractions and times into RFC 3339 in UTC.
+func canonicalValue(v interface{}) string {
+	var text string
+
+	switch v := v.(type) {
+	case []byte:
+		text = string(v)
+	case string:
+		text = v
+	case bool:
+		if v {
+			return "1"
+		}
+
+		return "0"
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		text = strconv.FormatFloat(v, 'f', -1, 64)
+	case float32:
+		text = strconv.FormatFloat(float64(v), 'f', -1, 32)
+	case time.Time:
+		return v.UTC().Format(time.RFC3339Nano)
+	default:
+		text = fmt.Sprint(v)
+	}
+
+	if numberRegexp.MatchString(text) {
+		if r, ok := new(big.Rat).SetString(text); ok {
+			return r.RatString()
+		}
+	}
+
+	if len(text) < 10 || text[4] != '-' {
+		return text
+	}
+
+	for _, layout := range timeLayouts {
+		if t, err := time.Parse(layout, text); err == nil {
+			return t.UTC().Format(time.RFC3339Nano)
+		}
+	}
+
+	return text
+}
+
+func hashValue(v interface{}) uint64 {
+	h := fnv.New64a()
+
+	if v == nil {
+		// distinguish NULL from any text
+		h.Write([]byte{0xff})
+	} else {
+		h.Write([]byte{0})
+		h.Write([]byte(canonicalValue(v)))
+	}
+
+	return h.Sum64()
+}
+
+func readResultSet(rows *sql.Rows) (*ResultSet, error) {
+	defer rows.Close()
+	columns, err := rows.Columns()
+
+	if err != nil {
+		return nil, err
+	}
+
+	rs := &ResultSet{
+		Columns:         columns,
+		ColumnChecksums: make([]uint64, len(columns)),
+	}
+
+	values := make([]interface{}, len(columns))
+	dest := make([]interface{}, len(columns))
+
+	for i := range values {
+		dest[i] = &values[i]
+	}
+
+	for rows.Next() {
+		err = rows.Scan(dest...)
+
+		if err != nil {
+			return nil, err
+		}
+
+		h := fnv.New64a()
+
+		for i, v := range values {
+			vh := hashValue(v)
+			rs.ColumnChecksums[i] += vh
+			fmt.Fprintf(h, "%016x", vh)
+		}
+
+		// the sum of row hashes does not depend on the row order
+		rs.Checksum += h.Sum64()
+		rs.Rows++
+	}
+
+	return rs, rows.Err()
+}
+
+// Diff returns a summary of the differences from the other result set, or an empty string if they are the same.
+// Column names are compared case-insensitively because databases fold unquoted identifiers differently.
+func (rs *ResultSet) Diff(other *ResultSet) string {
+	if !strings.EqualFold(strings.Join(rs.Columns, ","), strings.Join(other.Columns, ",")) {
+		return fmt.Sprintf("columns: %v != %v", rs.Columns, other.Columns)
+	}
+
+	diffs := []string{}
+
+	if rs.Rows != other.Rows {
+		diffs = append(diffs, fmt.Sprintf("rows: %d != %d", rs.Rows, other.Rows))
+	}
+
+	if rs.Checksum != other.Checksum {
+		columns := []string{}
+
+		for i, c := range rs.Columns {
+			if rs.ColumnChecksums[i] != other.ColumnChecksums[i] {
+				columns = append(columns, c)
+			}
+		}
+
+		if len(columns) > 0 {
+			diffs = append(diffs, fmt.Sprintf("values differ in columns: %s", strings.Join(columns, ", ")))
+		} else {
+			diffs = append(diffs, "values differ across rows")
+		}
+	}
+
+	return strings.Join(diffs, "; ")
+}
+
+// verify executes the query on the shadow and compares the result with the primary.
+func (agent *Agent) verify(ctx context.Context, query string, timeout time.Duration, result *ResultSet, primaryErr error) DataPoint {
+	rt, shadowResult, err := agent.Shadow.Fetch(ctx, query, timeout)
+	tm := time.Now()
+
+	dp := DataPoint{
+		Time:         tm,
+		ResponseTime: rt,
+		Fingerprint:  agent.fingerprint(query),
+		Agent:        agent.Id,
+		Target:       agent.Target,
+		Route:        RouteShadow,
+	}
+
+	if shadowResult != nil {
+		dp.Rows = int64(shadowResult.Rows)
+	}
+
+	var diff string
+
+	if err != nil {
+		dp.Error = ClassifyError(err)
+	}
+
+	if primaryErr != nil || err != nil {
+		if (primaryErr == nil) != (err == nil) {
+			diff = fmt.Sprintf("error: primary=%v, shadow=%v", primaryErr, err)
+		}
+	} else {
+		diff = result.Diff(shadowResult)
+	}
+
+	if diff != "" {
+		dp.Mismatch = true
+
+		if agent.ShadowLogger != nil {
+			agent.ShadowLogger.Log(query, diff, tm)
+		}
+	}
+
+	return dp
+}
+
+func (recorder *Recorder) shadowReport() *ShadowReport {
+	nanoElapsed := recorder.Finished.Sub(recorder.Started)
+
+	report := &ShadowReport{
+		DSN:               recorder.Shadow,
+		MismatchesByQuery: map[string]int{},
+	}
+
+	responseTimes := []DataPoint{}
+
+	for _, v := range recorder.ShadowPoints {
+		if v.Error != "" {
+			report.Errors++
+		} else {
+			responseTimes = append(responseTimes, v)
+		}
+
+		if v.Mismatch {
+			report.Mismatches++
+			report.MismatchesByQuery[v.Fingerprint]++
+		}
+	}
+
+	report.Queries = len(responseTimes)
+	report.QPS = float64(report.Queries) * float64(time.Second) / float64(nanoElapsed)
+	report.Response = recorder.calcMetrics(responseTimes)
+
+	if total := len(recorder.ShadowPoints); total > 0 {
+		report.ErrorRate = float64(report.Errors) / float64(total)
+		report.MismatchRate = float64(report.Mismatches) / float64(total)
+	}
+
+	return report
+}
@@ -0,0 +1,176 @@
+package qrn
+
+import (
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHashValue(t *testing.T) {
+	jst := time.FixedZone("JST", 9*60*60)
+	tm := time.Date(2021, 1, 2, 3, 4, 5, 600000000, time.UTC)
+
+	tests := []struct {
+		a     interface{}
+		b     interface{}
+		equal bool
+	}{
+		{[]byte("abc"), "abc", true},
+		{[]byte("1"), int64(1), true},
+		{[]byte("1.50"), float64(1.5), true},
+		{"-0.0", int64(0), true},
+		{"1e3", int64(1000), true},
+		{float32(0.1), "0.1", true},
+		{true, []byte("1"), true},
+		{false, int64(0), true},
+		{int32(7), "7", true},
+		{[]byte("2021-01-02 03:04:05.6"), tm, true},
+		{tm.In(jst), tm, true},
+		{"2021-01-02", time.Date(2021, 1, 2, 0, 0, 0, 0, time.UTC), true},
+		{"12345678901234567890.01", "12345678901234567890.010", true},
+		{"12345678901234567890.01", "12345678901234567890.02", false},
+		{float64(1.1), float64(1.1000001), false},
+		{"abc", "ABC", false},
+		{nil, "", false},
+		{nil, []byte{0xff}, false},
+		{"0x10", int64(16), false},
+		{tm, tm.Add(time.Microsecond), false},
+	}
+
+	for _, tt := range tests {
+		if equal := hashValue(tt.a) == hashValue(tt.b); equal != tt.equal {
+			t.Errorf("hashValue(%#v) == hashValue(%#v): expected %v, got %v", tt.a, tt.b, tt.equal, equal)
+		}
+	}
+}
+
+func TestResultSetDiff(t *testing.T) {
+	tests := []struct {
+		a        *ResultSet
+		b        *ResultSet
+		expected string
+	}{
+		{
+			&ResultSet{Columns: []string{"id", "name"}, Rows: 1, Checksum: 1, ColumnChecksums: []uint64{1, 2}},
+			&ResultSet{Columns: []string{"ID", "NAME"}, Rows: 1, Checksum: 1, ColumnChecksums: []uint64{1, 2}},
+			"",
+		},
+		{
+			&ResultSet{Columns: []string{"id", "name"}},
+			&ResultSet{Columns: []string{"id"}},
+			"columns: [id name] != [id]",
+		},
+		{
+			&ResultSet{Columns: []string{"id", "name"}, Rows: 2, Checksum: 1, ColumnChecksums: []uint64{1, 2}},
+			&ResultSet{Columns: []string{"id", "name"}, Rows: 1, Checksum: 2, ColumnChecksums: []uint64{1, 3}},
+			"rows: 2 != 1; values differ in columns: name",
+		},
+		{
+			&ResultSet{Columns: []string{"id"}, Rows: 2, Checksum: 1, ColumnChecksums: []uint64{1}},
+			&ResultSet{Columns: []string{"id"}, Rows: 2, Checksum: 2, ColumnChecksums: []uint64{1}},
+			"values differ across rows",
+		},
+	}
+
+	for _, tt := range tests {
+		if diff := tt.a.Diff(tt.b); diff != tt.expected {
+			t.Errorf("expected %q, got %q", tt.expected, diff)
+		}
+	}
+}
+
+// blockingWriter blocks writes until it is released.
+type blockingWriter struct {
+	release chan struct{}
+}
+
+func (w *blockingWriter) Write(p []byte) (int, error) {
+	<-w.release
+	return len(p), nil
+}
+
+func TestShadowLoggerDropsOverflow(t *testing.T) {
+	out := &blockingWriter{release: make(chan struct{})}
+	logger := NewShadowLogger(out)
+	n := ShadowLogBufferSize * 2
+	done := make(chan struct{})
+
+	go func() {
+		for i := 0; i < n; i++ {
+			logger.Log("select 1", "rows: 1 != 2", time.Now())
+		}
+
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Log blocked on a slow output")
+	}
+
+	close(out.release)
+	logger.Close()
+
+	if logger.Dropped == 0 || logger.Dropped >= int64(n) {
+		t.Errorf("expected some of %d logs to be dropped, got %d", n, logger.Dropped)
+	}
+}
+
+func TestShadowLoggerWritesAll(t *testing.T) {
+	r, w := io.Pipe()
+	logger := NewShadowLogger(w)
+	lines := make(chan int)
+
+	go func() {
+		buf, _ := ioutil.ReadAll(r)
+		lines <- strings.Count(string(buf), "\n")
+	}()
+
+	for i := 0; i < 10; i++ {
+		logger.Log("select 1", "rows: 1 != 2", time.Now())
+	}
+
+	logger.Close()
+	w.Close()
+
+	if n := <-lines; n != 10 {
+		t.Errorf("expected 10 lines, got %d", n)
+	}
+
+	if logger.Dropped != 0 {
+		t.Errorf("expected no dropped logs, got %d", logger.Dropped)
+	}
+}
+
+func TestShadowSkipsWrites(t *testing.T) {
+	tests := []struct {
+		writes   bool
+		expected int
+	}{
+		{false, 1},
+		{true, 2},
+	}
+
+	for _, tt := range tests {
+		options := &TaskOptions{
+			DSNs:         Strings{"fake:"},
+			ShadowDSN:    "fake:",
+			ShadowWrites: tt.writes,
+			Files:        Strings{testData(t, "select 1", "insert into t values (1)")},
+			NAgents:      1,
+		}
+
+		_, report, err := runTask(t, options, 0, 10*time.Millisecond)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if report.Shadow.Queries != tt.expected {
+			t.Errorf("expected %d shadow queries with writes=%v, got %d", tt.expected, tt.writes, report.Shadow.Queries)
+		}
+	}
+}
@@ -0,0 +1,19 @@
+package qrn
+
+import (
+	"strings"
+)
+
+func hasPrefixFold(s string, prefix string) bool {
+	return len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix)
+}
+
+func isTxBegin(query string) bool {
+	query = strings.TrimSpace(query)
+	return hasPrefixFold(query, "BEGIN") || hasPrefixFold(query, "START TRANSACTION")
+}
+
+func isTxEnd(query string) bool {
+	query = strings.TrimSpace(query)
+	return hasPrefixFold(query, "COMMIT") || hasPrefixFold(query, "ROLLBACK")
+}
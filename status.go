@@ -0,0 +1,85 @@
+package qrn
+
+import (
+	"database/sql"
+	"sync/atomic"
+)
+
+const (
+	AgentIdle int32 = iota
+	AgentRunning
+	AgentFinished
+	AgentFailed
+)
+
+var agentStateNames = map[int32]string{
+	AgentIdle:     "idle",
+	AgentRunning:  "running",
+	AgentFinished: "finished",
+	AgentFailed:   "failed",
+}
+
+// AgentStatus is a snapshot of an agent during the run.
+type AgentStatus struct {
+	Id      int
+	Target  int
+	State   string
+	Queries int64
+	Errors  int64
+}
+
+func (agent *Agent) Status() AgentStatus {
+	return AgentStatus{
+		Id:      agent.Id,
+		Target:  agent.Target,
+		State:   agentStateNames[atomic.LoadInt32(&agent.state)],
+		Queries: atomic.LoadInt64(&agent.Queries),
+		Errors:  atomic.LoadInt64(&agent.Errors),
+	}
+}
+
+// AgentStatuses returns the status of all agents, including removed and finished ones.
+func (task *Task) AgentStatuses() []AgentStatus {
+	task.Lock()
+	defer task.Unlock()
+	statuses := make([]AgentStatus, len(task.Agents))
+
+	for i, agent := range task.Agents {
+		statuses[i] = agent.Status()
+	}
+
+	return statuses
+}
+
+// PoolStats returns the sum of the connection pool stats of the running agents.
+func (task *Task) PoolStats() sql.DBStats {
+	task.Lock()
+	defer task.Unlock()
+	stats := sql.DBStats{}
+
+	for _, agent := range task.Agents {
+		// sessions are opened before the agent starts running
+		if atomic.LoadInt32(&agent.state) != AgentRunning {
+			continue
+		}
+
+		for _, s := range agent.sessions() {
+			if s.DB == nil {
+				continue
+			}
+
+			v := s.DB.Stats()
+			stats.MaxOpenConnections += v.MaxOpenConnections
+			stats.OpenConnections += v.OpenConnections
+			stats.InUse += v.InUse
+			stats.Idle += v.Idle
+			stats.WaitCount += v.WaitCount
+			stats.WaitDuration += v.WaitDuration
+			stats.MaxIdleClosed += v.MaxIdleClosed
+			stats.MaxIdleTimeClosed += v.MaxIdleTimeClosed
+			stats.MaxLifetimeClosed += v.MaxLifetimeClosed
+		}
+	}
+
+	return stats
+}
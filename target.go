@@ -0,0 +1,138 @@
+package qrn
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	BalanceRoundRobin   = "round-robin"
+	BalanceWeighted     = "weighted"
+	BalanceLeastLatency = "least-latency"
+)
+
+// Target is a database that agents are distributed across.
+// NAgents is the number of agents assigned to the target, excluding those that exited during the run.
+type Target struct {
+	Index    int
+	ConnInfo *ConnInfo
+	Weight   int
+	NAgents  int
+}
+
+type Ints []int
+
+func (ints *Ints) String() string {
+	return fmt.Sprintf("%v", *ints)
+}
+
+func (ints *Ints) Set(s string) error {
+	v, err := strconv.Atoi(s)
+
+	if err != nil {
+		return err
+	}
+
+	*ints = append(*ints, v)
+	return nil
+}
+
+// DetectDriver returns the database driver for the DSN.
+func DetectDriver(dsn string) string {
+	if strings.HasPrefix(dsn, "postgres:") || strings.HasPrefix(dsn, "postgresql:") {
+		return "pgx"
+	} else if strings.HasPrefix(dsn, "file:") {
+		return "sqlite"
+	} else if strings.HasPrefix(dsn, FakeDriverName+":") {
+		return FakeDriverName
+	}
+
+	return "mysql"
+}
+
+func newConnInfo(options *TaskOptions, dsn string) *ConnInfo {
+	driver := options.Driver
+
+	if driver == "" {
+		driver = DetectDriver(dsn)
+	}
+
+	return &ConnInfo{
+		Driver:       driver,
+		DSN:          dsn,
+		Host:         dsnHost(driver, dsn),
+		MaxIdleConns: options.NAgents,
+		Mode:         options.ConnMode,
+		Lifetime:     options.ConnLifetime,
+	}
+}
+
+func newTargets(options *TaskOptions) []*Target {
+	targets := make([]*Target, len(options.DSNs))
+
+	for i, dsn := range options.DSNs {
+		weight := 1
+
+		if i < len(options.Weights) {
+			weight = options.Weights[i]
+		}
+
+		targets[i] = &Target{
+			Index:    i,
+			ConnInfo: newConnInfo(options, dsn),
+			Weight:   weight,
+		}
+	}
+
+	return targets
+}
+
+// pickTarget chooses the target of a new agent.
+// Least-latency applies only to the agents added during the run. The initial agents are distributed by round-robin.
+func (task *Task) pickTarget() *Target {
+	targets := task.targets
+	picked := targets[0]
+
+	switch task.Options.Balance {
+	case BalanceWeighted:
+		// the target with the fewest agents relative to its weight
+		for _, t := range targets[1:] {
+			if (t.NAgents+1)*picked.Weight < (picked.NAgents+1)*t.Weight {
+				picked = t
+			}
+		}
+	case BalanceLeastLatency:
+		if task.recorder != nil {
+			found := false
+
+			for _, t := range targets {
+				mean, ok := task.recorder.MeanLatency(t.Index)
+
+				if !ok {
+					continue
+				}
+
+				if !found {
+					picked = t
+					found = true
+				} else if pickedMean, _ := task.recorder.MeanLatency(picked.Index); mean < pickedMean {
+					picked = t
+				}
+			}
+
+			if found {
+				break
+			}
+		}
+
+		// no latency is measured yet
+		fallthrough
+	default:
+		picked = targets[len(task.Agents)%len(targets)]
+	}
+
+	picked.NAgents++
+
+	return picked
+}
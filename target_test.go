@@ -0,0 +1,87 @@
+package qrn
+
+import (
+	"testing"
+	"time"
+)
+
+func targetAgents(report *RecordReport) []int {
+	agents := []int{}
+
+	for _, v := range report.Targets {
+		agents = append(agents, v.NAgents)
+	}
+
+	return agents
+}
+
+func TestPickTarget(t *testing.T) {
+	tests := []struct {
+		name     string
+		dsns     Strings
+		balance  string
+		weights  Ints
+		nagents  int
+		expected []int
+	}{
+		{"round-robin", Strings{"fake:latency=1ms", "fake:latency=2ms", "fake:latency=3ms"}, BalanceRoundRobin, nil, 7, []int{3, 2, 2}},
+		{"weighted", Strings{"fake:latency=1ms", "fake:latency=2ms"}, BalanceWeighted, Ints{1, 3}, 8, []int{2, 6}},
+		{"weighted without weights", Strings{"fake:latency=1ms", "fake:latency=2ms"}, BalanceWeighted, nil, 5, []int{3, 2}},
+		// the initial agents are distributed by round-robin
+		{"least-latency", Strings{"fake:latency=1ms", "fake:latency=2ms"}, BalanceLeastLatency, nil, 4, []int{2, 2}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options := &TaskOptions{
+				DSNs:    tt.dsns,
+				Balance: tt.balance,
+				Weights: tt.weights,
+				Files:   Strings{testData(t, "select 1")},
+				Loop:    true,
+				NAgents: tt.nagents,
+			}
+
+			_, report, err := runTask(t, options, 100*time.Millisecond, 10*time.Millisecond)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if agents := targetAgents(report); !equalInts(agents, tt.expected) {
+				t.Errorf("expected agents %v, got %v", tt.expected, agents)
+			}
+
+			for _, v := range report.Targets {
+				if v.Queries == 0 {
+					t.Errorf("expected queries on %s", v.DSN)
+				}
+			}
+		})
+	}
+}
+
+func TestPickTargetLeastLatency(t *testing.T) {
+	options := &TaskOptions{
+		DSNs:     Strings{"fake:latency=20ms", "fake:latency=1ms"},
+		Balance:  BalanceLeastLatency,
+		Files:    Strings{testData(t, "select 1")},
+		Loop:     true,
+		NAgents:  2,
+		RampStep: 4,
+		// after the agents have sent their latency once
+		RampInterval: AgentInterruptPeriod + 500*time.Millisecond,
+		RampLimit:    6,
+	}
+
+	_, report, err := runTask(t, options, 2*AgentInterruptPeriod, 10*time.Millisecond)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the agents added by the ramp go to the faster target
+	if agents := targetAgents(report); !equalInts(agents, []int{1, 5}) {
+		t.Errorf("expected agents [1 5], got %v", agents)
+	}
+}
@@ -3,6 +3,8 @@ package qrn
 import (
 	"context"
 	"fmt"
+	"math/rand"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -11,9 +13,24 @@ import (
 )
 
 type Task struct {
-	Agents  []*Agent
-	Options *TaskOptions
-	Token   string
+	sync.Mutex
+	Agents        []*Agent
+	Options       *TaskOptions
+	Token         string
+	active        []*Agent
+	running       int32
+	scaling       sync.Mutex
+	ctx           context.Context
+	queryCtx      context.Context
+	cancel        context.CancelFunc
+	cancelQueries context.CancelFunc
+	eg            *errgroup.Group
+	recorder      *Recorder
+	throttle      *Throttle
+	targets       []*Target
+	replicas      []*ConnInfo
+	shadow        *ConnInfo
+	observers     []Observer
 }
 
 type Strings []string
@@ -28,66 +45,136 @@ func (files *Strings) Set(f string) error {
 }
 
 type TaskOptions struct {
-	Driver      string
-	DSN         string
-	NAgents     int
-	Rate        int
-	Files       Strings
-	Key         string
-	Loop        bool
-	Force       bool
-	MaxCount    int64
-	Random      bool
-	PreQueries  Strings
-	CommitRate  int64
-	HBins       int
-	HInterval   time.Duration
-	QPSInterval time.Duration
-	Logger      *Logger
+	Driver         string
+	DSNs           Strings
+	ReplicaDSNs    Strings
+	ShadowDSN      string
+	ShadowLogger   *ShadowLogger
+	ShadowWrites   bool
+	Tracer         *Tracer
+	Weights        Ints
+	Balance        string
+	Compare        bool
+	NAgents        int
+	Rate           int
+	Files          Strings
+	Key            string
+	Loop           bool
+	Force          bool
+	MaxCount       int64
+	Random         bool
+	PreQueries     Strings
+	CommitRate     int64
+	HBins          int
+	HInterval      time.Duration
+	QPSInterval    time.Duration
+	TimeSeries     *TimeSeries
+	HistogramLog   *HistogramLog
+	RampStep       int
+	RampInterval   time.Duration
+	RampLimit      int
+	Assertions     Assertions
+	AssertInterval time.Duration
+	Reconnect      bool
+	QueryTimeout   time.Duration
+	TimeoutKey     string
+	ClampTimeouts  bool
+	ConnMode       string
+	ConnLifetime   Distribution
+	Logger         *Logger
 }
 
 func NewTask(options *TaskOptions) *Task {
-	agents := make([]*Agent, options.NAgents)
 	uuid, _ := uuid.NewRandom()
 
-	connInfo := &ConnInfo{
-		Driver:       options.Driver,
-		DSN:          options.DSN,
-		MaxIdleConns: options.NAgents,
-	}
-
-	files := options.Files
-	flen := len(options.Files)
-
-	for i := 0; i < options.NAgents; i++ {
-		data := &Data{
-			Path:       files[i%flen],
-			Key:        options.Key,
-			Loop:       options.Loop,
-			Force:      options.Force,
-			Random:     options.Random,
-			Rate:       options.Rate,
-			MaxCount:   options.MaxCount,
-			CommitRate: options.CommitRate,
-		}
+	task := &Task{
+		Agents:   []*Agent{},
+		Options:  options,
+		Token:    uuid.String(),
+		throttle: NewThrottle(options.Rate),
+		targets:  newTargets(options),
+	}
 
-		agents[i] = &Agent{
-			ConnInfo: connInfo,
-			Data:     data,
-			Logger:   options.Logger,
-			Token:    uuid.String(),
-		}
+	if options.Tracer != nil {
+		options.Tracer.Token = task.Token
 	}
 
-	task := &Task{
-		Agents:  agents,
-		Options: options,
-		Token:   uuid.String(),
+	if options.ShadowDSN != "" {
+		task.shadow = newConnInfo(options, options.ShadowDSN)
+	}
+
+	for _, dsn := range options.ReplicaDSNs {
+		task.replicas = append(task.replicas, newConnInfo(options, dsn))
+	}
+
+	for i := 0; i < task.perTarget(options.NAgents); i++ {
+		task.newAgent()
 	}
 
 	return task
 }
 
+func (task *Task) newAgent() *Agent {
+	options := task.Options
+	id := len(task.Agents)
+	target := task.pickTarget()
+	file := id
+
+	if options.Compare {
+		file = id / len(task.targets)
+	}
+
+	data := &Data{
+		Path:       options.Files[file%len(options.Files)],
+		Key:        options.Key,
+		Loop:       options.Loop,
+		Force:      options.Force,
+		Random:     options.Random,
+		Rate:       options.Rate,
+		MaxCount:   options.MaxCount,
+		CommitRate: options.CommitRate,
+		Throttle:   task.throttle,
+		TimeoutKey: options.TimeoutKey,
+	}
+
+	if options.Compare && options.Random {
+		// twin agents of A/B comparison start at the same position
+		twin := id - id%len(task.targets)
+
+		if twin == id {
+			data.Seed = rand.Int63() + 1
+		} else {
+			data.Seed = task.Agents[twin].Data.Seed
+		}
+	}
+
+	agent := &Agent{
+		Id:           id,
+		Target:       target.Index,
+		ConnInfo:     target.ConnInfo,
+		Data:         data,
+		Logger:       options.Logger,
+		Token:        task.Token,
+		Reconnect:    options.Reconnect,
+		QueryTimeout: options.QueryTimeout,
+		Tracer:       options.Tracer,
+	}
+
+	if task.shadow != nil {
+		agent.ShadowConnInfo = task.shadow
+		agent.ShadowLogger = options.ShadowLogger
+		agent.ShadowWrites = options.ShadowWrites
+	}
+
+	if len(task.replicas) > 0 {
+		agent.ReplicaConnInfo = task.replicas[id%len(task.replicas)]
+	}
+
+	task.Agents = append(task.Agents, agent)
+
+	return agent
+}
+
 func (task *Task) Prepare() error {
 	for _, agent := range task.Agents {
 		if err := agent.Prepare(task.Options.PreQueries); err != nil {
@@ -98,16 +185,27 @@ func (task *Task) Prepare() error {
 	return nil
 }
 
+func (task *Task) Running() int {
+	return int(atomic.LoadInt32(&task.running))
+}
+
 func (task *Task) Run(n time.Duration, reportPeriod time.Duration, report func(*Recorder, int)) (*Recorder, error) {
 	recorder := &Recorder{
-		DSN:       task.Options.DSN,
-		Files:     task.Options.Files,
-		PreQueris: task.Options.PreQueries,
-		NAgents:   task.Options.NAgents,
-		Rate:      task.Options.Rate,
-		HBins:     task.Options.HBins,
-		HInterval: task.Options.HInterval,
-		Token:     task.Token,
+		DSN:           task.Options.DSNs[0],
+		Targets:       task.targets,
+		Replicas:      task.Options.ReplicaDSNs,
+		Compare:       task.Options.Compare,
+		Shadow:        task.Options.ShadowDSN,
+		Observers:     task.observers,
+		Files:         task.Options.Files,
+		PreQueris:     task.Options.PreQueries,
+		NAgents:       task.Options.NAgents,
+		Rate:          task.Options.Rate,
+		HBins:         task.Options.HBins,
+		HInterval:     task.Options.HInterval,
+		Token:         task.Token,
+		Assertions:    task.Options.Assertions,
+		ClampTimeouts: task.Options.ClampTimeouts,
 	}
 
 	defer func() {
@@ -120,27 +218,90 @@ func (task *Task) Run(n time.Duration, reportPeriod time.Duration, report func(*
 
 	eg, ctx := errgroup.WithContext(context.Background())
 	ctxWithCancel, cancel := context.WithCancel(ctx)
+	queryCtx, cancelQueries := context.WithCancel(ctx)
 	ticker := time.NewTicker(reportPeriod)
-	recorder.Start(task.Options.NAgents * 3)
-	var doneCnt int32
-
-	for _, v := range task.Agents {
-		agent := v
-		eg.Go(func() error {
-			err := agent.Run(ctxWithCancel, recorder)
-			atomic.AddInt32(&doneCnt, 1)
-			return err
-		})
+	bufsize := task.perTarget(task.Options.NAgents)
+
+	if limit := task.perTarget(task.Options.RampLimit); limit > bufsize {
+		bufsize = limit
+	}
+
+	recorder.Start(bufsize * 3)
+
+	if ts := task.Options.TimeSeries; ts != nil {
+		recorder.TimeSeries = ts
+		ts.begin(recorder)
+		go ts.run(ctxWithCancel)
+	}
+
+	if hl := task.Options.HistogramLog; hl != nil {
+		recorder.HistogramLog = hl
+		hl.begin(recorder)
+		go hl.run(ctxWithCancel)
+	}
+
+	task.Lock()
+	task.ctx = ctxWithCancel
+	task.queryCtx = queryCtx
+	task.cancel = cancel
+	task.cancelQueries = cancelQueries
+	task.eg = eg
+	task.recorder = recorder
+	task.Unlock()
+
+	// held until all agents have started, so that the run does not finish when the first agents exit
+	atomic.AddInt32(&task.running, 1)
+	started := 0
+
+	for _, agent := range task.Agents {
+		if task.startAgent(agent) {
+			started++
+		}
+	}
+
+	recorder.AddConcurrency(started)
+	task.exit()
+
+	var rampErr error
+	rampDone := make(chan struct{})
+
+	if task.Options.RampStep != 0 && task.Options.RampInterval > 0 {
+		// the ramp is not in eg, so that Run returns when the agents are done before the end of the schedule
+		go func() {
+			defer close(rampDone)
+			rampErr = task.ramp()
+
+			if rampErr != nil {
+				task.Stop()
+			}
+		}()
+	} else {
+		close(rampDone)
+	}
+
+	if len(task.Options.Assertions) > 0 && task.Options.AssertInterval > 0 {
+		go task.assert(ctxWithCancel)
 	}
 
+	reporterDone := make(chan struct{})
+
 	go func() {
+		defer close(reporterDone)
+
 	LOOP:
 		for {
 			select {
 			case <-ctx.Done():
 				break LOOP
 			case <-ticker.C:
-				report(recorder, task.Options.NAgents-int(doneCnt))
+				running := task.Running()
+
+				// agents exiting at the end of the run are not recorded
+				if ctxWithCancel.Err() == nil {
+					recorder.AddConcurrency(running)
+				}
+
+				report(recorder, running)
 			}
 		}
 	}()
@@ -151,13 +312,303 @@ func (task *Task) Run(n time.Duration, reportPeriod time.Duration, report func(*
 			case <-ctx.Done():
 				// nothing to do
 			case <-time.After(n):
-				cancel()
+				task.Stop()
 			}
 		}()
 	}
 
 	err := eg.Wait()
 	cancel()
+	cancelQueries()
+	<-rampDone
+	<-reporterDone
+
+	// reject Scale from now on, and wait for the agents added while the run was finishing
+	task.scaling.Lock()
+	task.Lock()
+	task.eg = nil
+	task.Unlock()
+	task.scaling.Unlock()
+	err = eg.Wait()
+
+	if err == nil {
+		err = rampErr
+	}
 
 	return recorder, err
 }
+
+// startAgent starts the agent unless the run has finished. It returns false if the agent is not started.
+func (task *Task) startAgent(agent *Agent) bool {
+	task.Lock()
+	defer task.Unlock()
+
+	// no agent is added to eg after all agents have exited, so that eg is not reused while Run waits for it
+	if task.ctx.Err() != nil {
+		return false
+	}
+
+	ctx, cancelRun := context.WithCancel(task.ctx)
+	queryCtx, cancelQueries := context.WithCancel(task.queryCtx)
+
+	cancel := func() {
+		cancelRun()
+		cancelQueries()
+	}
+
+	agent.cancel = cancel
+	agent.queryCtx = queryCtx
+	task.active = append(task.active, agent)
+	atomic.AddInt32(&task.running, 1)
+	atomic.StoreInt32(&agent.state, AgentRunning)
+
+	task.eg.Go(func() error {
+		defer cancel()
+		err := agent.Run(ctx, task.recorder)
+
+		if err != nil {
+			atomic.StoreInt32(&agent.state, AgentFailed)
+		} else {
+			atomic.StoreInt32(&agent.state, AgentFinished)
+		}
+
+		agent.Close()
+		task.deactivate(agent)
+		task.exit()
+		return err
+	})
+
+	return true
+}
+
+// exit counts down the running agents, and finishes the run when all agents have exited.
+func (task *Task) exit() {
+	task.Lock()
+	defer task.Unlock()
+
+	if atomic.AddInt32(&task.running, -1) == 0 {
+		task.cancel()
+	}
+}
+
+// deactivate removes the agent that has exited (finished, failed or removed) from the active agents.
+func (task *Task) deactivate(agent *Agent) {
+	task.Lock()
+	defer task.Unlock()
+	active := make([]*Agent, 0, len(task.active))
+
+	for _, v := range task.active {
+		if v != agent {
+			active = append(active, v)
+		}
+	}
+
+	task.active = active
+
+	// agents stopped at the end of the run remain counted in the report
+	if task.ctx.Err() == nil {
+		task.targets[agent.Target].NAgents--
+	}
+}
+
+// perTarget returns the total number of agents for n agents on each target of A/B comparison.
+func (task *Task) perTarget(n int) int {
+	if task.Options.Compare {
+		return n * len(task.targets)
+	}
+
+	return n
+}
+
+// Scale adds or removes agents during Run until n agents are running. Agents that have exited are not counted.
+// In A/B comparison, n is the number of agents for each target.
+// New agents connect before they start; removed agents cancel their in-flight query and disconnect.
+func (task *Task) Scale(n int) error {
+	n = task.perTarget(n)
+	task.scaling.Lock()
+	defer task.scaling.Unlock()
+
+	task.Lock()
+
+	if task.eg == nil {
+		task.Unlock()
+		return fmt.Errorf("task is not running")
+	}
+
+	current := len(task.active)
+
+	if n < current {
+		removed := append([]*Agent{}, task.active[n:]...)
+		task.active = task.active[:n:n]
+		task.Unlock()
+
+		for _, agent := range removed {
+			agent.cancel()
+		}
+
+		return nil
+	}
+
+	task.Unlock()
+
+	for i := current; i < n; i++ {
+		if task.ctx.Err() != nil {
+			return fmt.Errorf("task is not running")
+		}
+
+		task.Lock()
+		agent := task.newAgent()
+		task.Unlock()
+
+		if err := agent.Prepare(task.Options.PreQueries); err != nil {
+			return err
+		}
+
+		if !task.startAgent(agent) {
+			agent.Close()
+			return fmt.Errorf("task is not running")
+		}
+	}
+
+	return nil
+}
+
+// AddObserver adds an observer of the data points. It must be called before Run.
+func (task *Task) AddObserver(o Observer) {
+	task.observers = append(task.observers, o)
+}
+
+// Recorder returns the recorder of the running task, or nil if the task has not started.
+func (task *Task) Recorder() *Recorder {
+	task.Lock()
+	defer task.Unlock()
+	return task.recorder
+}
+
+func (task *Task) Rate() int {
+	return task.throttle.Rate()
+}
+
+func (task *Task) SetRate(rate int) {
+	task.throttle.SetRate(rate)
+}
+
+func (task *Task) Pause() {
+	task.throttle.Pause()
+}
+
+func (task *Task) Resume() {
+	task.throttle.Resume()
+}
+
+func (task *Task) Paused() bool {
+	return task.throttle.Paused()
+}
+
+// Stop cancels the in-flight queries and makes Run return as if the run time had elapsed.
+func (task *Task) Stop() {
+	task.stop(false)
+}
+
+// Drain stops the run like Stop, but lets the agents finish their in-flight queries.
+func (task *Task) Drain() {
+	task.stop(true)
+}
+
+// stop cancels the agents. If graceful, the in-flight queries are not cancelled but left to finish.
+func (task *Task) stop(graceful bool) {
+	task.Lock()
+	cancel, cancelQueries := task.cancel, task.cancelQueries
+	task.Unlock()
+
+	if cancel != nil {
+		cancel()
+	}
+
+	if cancelQueries != nil && !graceful {
+		cancelQueries()
+	}
+}
+
+// Interrupt stops the run, lets the agents finish their in-flight queries, and marks the report as interrupted.
+// The queries can be cancelled by calling Stop afterwards.
+// It returns false if the task is not running.
+func (task *Task) Interrupt() bool {
+	recorder := task.Recorder()
+
+	if recorder == nil {
+		return false
+	}
+
+	recorder.Lock()
+	recorder.Interrupted = true
+	recorder.Unlock()
+	recorder.Annotate("interrupted")
+	task.stop(true)
+
+	return true
+}
+
+func (task *Task) ramp() error {
+	ticker := time.NewTicker(task.Options.RampInterval)
+	defer ticker.Stop()
+	step := task.Options.RampStep
+	limit := task.Options.RampLimit
+	n := task.Options.NAgents
+
+	for {
+		select {
+		case <-task.ctx.Done():
+			return nil
+		case <-ticker.C:
+			n += step
+
+			if (step > 0 && n >= limit) || (step < 0 && n <= limit) {
+				n = limit
+			}
+
+			if err := task.Scale(n); err != nil {
+				if task.ctx.Err() != nil {
+					// the run has finished during the step
+					return nil
+				}
+
+				return err
+			}
+
+			if n == limit {
+				return nil
+			}
+		}
+	}
+}
+
+// assert checks the assertions periodically and stops the run at the first failure.
+func (task *Task) assert(ctx context.Context) {
+	ticker := time.NewTicker(task.Options.AssertInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			report := task.recorder.Interim()
+
+			for _, v := range task.Options.Assertions {
+				if !v.Continuous() || (v.isLatency() && report.Queries == 0) {
+					continue
+				}
+
+				if result := v.Check(report); !result.Passed {
+					task.recorder.Lock()
+					task.recorder.Aborted = true
+					task.recorder.Unlock()
+					task.recorder.Annotate(fmt.Sprintf("aborted: %s (actual %s)", result.Assertion, result.Actual))
+					task.Stop()
+					return
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package qrn
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testData writes the queries as a data file and returns its path.
+func testData(t *testing.T, queries ...string) string {
+	t.Helper()
+	lines := []string{}
+
+	for _, q := range queries {
+		lines = append(lines, `{"query":"`+q+`"}`)
+	}
+
+	path := filepath.Join(t.TempDir(), "data.jsonl")
+	err := ioutil.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0644)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func runTask(t *testing.T, options *TaskOptions, n time.Duration, reportPeriod time.Duration) (*Task, *RecordReport, error) {
+	t.Helper()
+
+	if options.Key == "" {
+		options.Key = "query"
+	}
+
+	if options.Logger == nil {
+		options.Logger = &Logger{Null: true}
+	}
+
+	task := NewTask(options)
+	err := task.Prepare()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder, err := task.Run(n, reportPeriod, func(*Recorder, int) {})
+
+	return task, recorder.Report(), err
+}
+
+func concurrency(report *RecordReport) []int {
+	agents := []int{}
+
+	for _, v := range report.Concurrency {
+		agents = append(agents, v.NAgents)
+	}
+
+	return agents
+}
+
+func TestRampSchedule(t *testing.T) {
+	tests := []struct {
+		name     string
+		nagents  int
+		step     int
+		limit    int
+		expected []int
+	}{
+		{"up", 1, 1, 3, []int{1, 2, 3}},
+		{"up over the limit", 1, 2, 4, []int{1, 3, 4}},
+		{"down", 3, -1, 1, []int{3, 2, 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options := &TaskOptions{
+				DSNs:         Strings{"fake:latency=1ms"},
+				Files:        Strings{testData(t, "select 1")},
+				Loop:         true,
+				NAgents:      tt.nagents,
+				RampStep:     tt.step,
+				RampInterval: 100 * time.Millisecond,
+				RampLimit:    tt.limit,
+			}
+
+			_, report, err := runTask(t, options, 500*time.Millisecond, 10*time.Millisecond)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if agents := concurrency(report); !equalInts(agents, tt.expected) {
+				t.Errorf("expected concurrency %v, got %v", tt.expected, agents)
+			}
+
+			max := tt.nagents
+
+			if tt.limit > max {
+				max = tt.limit
+			}
+
+			if report.MaxAgents != max {
+				t.Errorf("expected %d max agents, got %d", max, report.MaxAgents)
+			}
+		})
+	}
+}
+
+func TestConcurrencyWithoutRamp(t *testing.T) {
+	options := &TaskOptions{
+		DSNs:    Strings{"fake:latency=1ms"},
+		Files:   Strings{testData(t, "select 1")},
+		Loop:    true,
+		NAgents: 4,
+	}
+
+	_, report, err := runTask(t, options, 200*time.Millisecond, 10*time.Millisecond)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a point at the start, not one for each start and exit of the agents
+	if agents := concurrency(report); !equalInts(agents, []int{4}) {
+		t.Errorf("expected concurrency [4], got %v", agents)
+	}
+}
+
+func TestRampStopsWithAgents(t *testing.T) {
+	options := &TaskOptions{
+		DSNs:         Strings{"fake:"},
+		Files:        Strings{testData(t, "select 1", "select 2", "select 3")},
+		NAgents:      2,
+		RampStep:     1,
+		RampInterval: time.Hour,
+		RampLimit:    10,
+	}
+
+	done := make(chan *RecordReport)
+
+	go func() {
+		// without the time limit, the run ends when the agents have read the data
+		_, report, err := runTask(t, options, 0, 10*time.Millisecond)
+
+		if err != nil {
+			t.Error(err)
+		}
+
+		done <- report
+	}()
+
+	select {
+	case report := <-done:
+		if report.Queries != 6 {
+			t.Errorf("expected 6 queries, got %d", report.Queries)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Run did not return when the agents finished")
+	}
+}
+
+func equalInts(a []int, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,75 @@
+package qrn
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// Throttle is shared by the agents of a task so that the rate and the pause state can be changed during a run.
+type Throttle struct {
+	sync.Mutex
+	rate   int64
+	epoch  int64
+	resume chan struct{}
+}
+
+func NewThrottle(rate int) *Throttle {
+	return &Throttle{rate: int64(rate)}
+}
+
+func (throttle *Throttle) Rate() int {
+	return int(atomic.LoadInt64(&throttle.rate))
+}
+
+func (throttle *Throttle) SetRate(rate int) {
+	atomic.StoreInt64(&throttle.rate, int64(rate))
+	atomic.AddInt64(&throttle.epoch, 1)
+}
+
+// Epoch is incremented whenever the rate changes or the throttle is resumed, so the rate limiter can reset its state.
+func (throttle *Throttle) Epoch() int64 {
+	return atomic.LoadInt64(&throttle.epoch)
+}
+
+func (throttle *Throttle) Pause() {
+	throttle.Lock()
+	defer throttle.Unlock()
+
+	if throttle.resume == nil {
+		throttle.resume = make(chan struct{})
+	}
+}
+
+func (throttle *Throttle) Resume() {
+	throttle.Lock()
+	defer throttle.Unlock()
+
+	if throttle.resume != nil {
+		close(throttle.resume)
+		throttle.resume = nil
+		atomic.AddInt64(&throttle.epoch, 1)
+	}
+}
+
+func (throttle *Throttle) Paused() bool {
+	throttle.Lock()
+	defer throttle.Unlock()
+	return throttle.resume != nil
+}
+
+// Wait blocks while the throttle is paused.
+func (throttle *Throttle) Wait(ctx context.Context) {
+	throttle.Lock()
+	resume := throttle.resume
+	throttle.Unlock()
+
+	if resume == nil {
+		return
+	}
+
+	select {
+	case <-ctx.Done():
+	case <-resume:
+	}
+}
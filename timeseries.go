@@ -0,0 +1,214 @@
+package qrn
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+const (
+	TimeSeriesCSV   = "csv"
+	TimeSeriesJSONL = "jsonl"
+)
+
+const DefaultTimeSeriesInterval = 1 * time.Second
+
+type TimeSeriesPoint struct {
+	Time    time.Time     `json:"time"`
+	QPS     float64       `json:"qps"`
+	P50     time.Duration `json:"p50"`
+	P95     time.Duration `json:"p95"`
+	P99     time.Duration `json:"p99"`
+	Max     time.Duration `json:"max"`
+	Errors  int           `json:"errors"`
+	NAgents int           `json:"agents"`
+}
+
+// TimeSeries writes the throughput and latency of each interval during the run.
+// Agents send their data points every AgentInterruptPeriod, so an interval is written
+// after AgentInterruptPeriod has passed since its end.
+type TimeSeries struct {
+	sync.Mutex
+	Interval    time.Duration
+	Format      string
+	Out         io.Writer
+	recorder    *Recorder
+	csv         *csv.Writer
+	pending     []DataPoint
+	responsePos int
+	errorPos    int
+	start       time.Time
+}
+
+func NewTimeSeries(out io.Writer, format string, interval time.Duration) *TimeSeries {
+	if interval <= 0 {
+		interval = DefaultTimeSeriesInterval
+	}
+
+	ts := &TimeSeries{
+		Interval: interval,
+		Format:   format,
+		Out:      out,
+	}
+
+	if format == TimeSeriesCSV {
+		ts.csv = csv.NewWriter(out)
+	}
+
+	return ts
+}
+
+func (ts *TimeSeries) begin(recorder *Recorder) {
+	ts.recorder = recorder
+	ts.start = recorder.Started
+
+	if ts.csv != nil {
+		ts.csv.Write([]string{"time", "qps", "p50_ms", "p95_ms", "p99_ms", "max_ms", "errors", "agents"})
+		ts.csv.Flush()
+	}
+}
+
+func (ts *TimeSeries) run(ctx context.Context) {
+	ticker := time.NewTicker(ts.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			ts.flush(time.Now().Add(-AgentInterruptPeriod))
+		}
+	}
+}
+
+// flush writes the intervals that end before the cutoff.
+func (ts *TimeSeries) flush(cutoff time.Time) {
+	ts.Lock()
+	defer ts.Unlock()
+
+	if ts.recorder == nil {
+		return
+	}
+
+	ts.pending, ts.responsePos, ts.errorPos = ts.recorder.pointsSince(ts.pending, ts.responsePos, ts.errorPos)
+	sort.Slice(ts.pending, func(i, j int) bool { return ts.pending[i].Time.Before(ts.pending[j].Time) })
+
+	for !ts.start.Add(ts.Interval).After(cutoff) {
+		end := ts.start.Add(ts.Interval)
+		ts.write(ts.aggregate(end))
+		ts.start = end
+	}
+}
+
+// finish writes the remaining intervals including the last partial one.
+func (ts *TimeSeries) finish(finished time.Time) {
+	ts.flush(finished)
+
+	ts.Lock()
+	defer ts.Unlock()
+
+	if ts.recorder != nil && finished.After(ts.start) {
+		ts.write(ts.aggregate(finished))
+		ts.start = finished
+	}
+}
+
+// aggregate removes the data points before the end of the interval from pending and summarizes them.
+// Points that arrive late are counted in the current interval.
+func (ts *TimeSeries) aggregate(end time.Time) *TimeSeriesPoint {
+	point := &TimeSeriesPoint{
+		Time:    end,
+		NAgents: ts.recorder.maxConcurrency(ts.start, end),
+	}
+
+	responseTimes := []DataPoint{}
+	// pending is sorted by time
+	n := sort.Search(len(ts.pending), func(i int) bool { return !ts.pending[i].Time.Before(end) })
+
+	for _, v := range ts.pending[:n] {
+		if v.Error != "" {
+			point.Errors++
+		} else {
+			responseTimes = append(responseTimes, v)
+		}
+	}
+
+	ts.pending = ts.pending[n:]
+	point.QPS = float64(len(responseTimes)) * float64(time.Second) / float64(end.Sub(ts.start))
+
+	if len(responseTimes) > 0 {
+		metrics := ts.recorder.calcMetrics(responseTimes)
+		point.P50 = metrics.Time.P50
+		point.P95 = metrics.Time.P95
+		point.P99 = metrics.Time.P99
+		point.Max = metrics.Time.Max
+	}
+
+	return point
+}
+
+func (ts *TimeSeries) write(point *TimeSeriesPoint) {
+	if ts.csv != nil {
+		ms := func(d time.Duration) string {
+			return strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 3, 64)
+		}
+
+		ts.csv.Write([]string{
+			point.Time.Format(time.RFC3339Nano),
+			strconv.FormatFloat(point.QPS, 'f', 2, 64),
+			ms(point.P50),
+			ms(point.P95),
+			ms(point.P99),
+			ms(point.Max),
+			strconv.Itoa(point.Errors),
+			strconv.Itoa(point.NAgents),
+		})
+
+		ts.csv.Flush()
+		return
+	}
+
+	line, _ := jsoniter.MarshalToString(point)
+	fmt.Fprintln(ts.Out, line)
+}
+
+// pointsSince appends the data points added after the positions, except for shadow queries.
+func (recorder *Recorder) pointsSince(points []DataPoint, responsePos int, errorPos int) ([]DataPoint, int, int) {
+	recorder.Lock()
+	defer recorder.Unlock()
+
+	points = append(points, recorder.ResponseTimes[responsePos:]...)
+	points = append(points, recorder.ErrorPoints[errorPos:]...)
+
+	return points, len(recorder.ResponseTimes), len(recorder.ErrorPoints)
+}
+
+// maxConcurrency returns the maximum number of running agents between start and end.
+func (recorder *Recorder) maxConcurrency(start time.Time, end time.Time) int {
+	recorder.Lock()
+	defer recorder.Unlock()
+	n := 0
+	max := 0
+
+	for _, v := range recorder.Concurrency {
+		if v.Time.After(end) {
+			break
+		}
+
+		n = v.NAgents
+
+		if n > max || !v.Time.After(start) {
+			max = n
+		}
+	}
+
+	return max
+}
@@ -0,0 +1,125 @@
+package qrn
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+func readTimeSeries(t *testing.T, buf *bytes.Buffer) []TimeSeriesPoint {
+	t.Helper()
+	points := []TimeSeriesPoint{}
+	scanner := bufio.NewScanner(buf)
+
+	for scanner.Scan() {
+		point := TimeSeriesPoint{}
+
+		if err := jsoniter.Unmarshal(scanner.Bytes(), &point); err != nil {
+			t.Fatal(err)
+		}
+
+		points = append(points, point)
+	}
+
+	return points
+}
+
+func TestTimeSeriesFlush(t *testing.T) {
+	t0 := time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC)
+	at := func(d time.Duration) time.Time { return t0.Add(d) }
+
+	recorder := &Recorder{
+		Started:       t0,
+		HBins:         10,
+		ResponseTimes: []DataPoint{},
+		Concurrency: []ConcurrencyPoint{
+			{Time: at(0), NAgents: 2},
+			{Time: at(1200 * time.Millisecond), NAgents: 4},
+		},
+	}
+
+	addPoints := func(points ...DataPoint) {
+		for _, v := range points {
+			if v.Error != "" {
+				recorder.ErrorPoints = append(recorder.ErrorPoints, v)
+			} else {
+				recorder.ResponseTimes = append(recorder.ResponseTimes, v)
+			}
+		}
+	}
+
+	addPoints(
+		DataPoint{Time: at(200 * time.Millisecond), ResponseTime: 3 * time.Millisecond},
+		DataPoint{Time: at(100 * time.Millisecond), ResponseTime: 1 * time.Millisecond},
+		DataPoint{Time: at(500 * time.Millisecond), ResponseTime: time.Second, Error: ErrorClassTimeout},
+		DataPoint{Time: at(1500 * time.Millisecond), ResponseTime: 2 * time.Millisecond},
+	)
+
+	var buf bytes.Buffer
+	ts := NewTimeSeries(&buf, TimeSeriesJSONL, time.Second)
+	ts.begin(recorder)
+
+	// the second interval is not written until the cutoff passes its end
+	ts.flush(at(1900 * time.Millisecond))
+	points := readTimeSeries(t, &buf)
+
+	if len(points) != 1 {
+		t.Fatalf("expected 1 interval, got %d", len(points))
+	}
+
+	p := points[0]
+
+	if !p.Time.Equal(at(time.Second)) || p.QPS != 2 || p.Max != 3*time.Millisecond || p.Errors != 1 || p.NAgents != 2 {
+		t.Errorf("unexpected first interval: %+v", p)
+	}
+
+	// a point of the first interval arrives late, and a point of the last partial interval
+	addPoints(
+		DataPoint{Time: at(900 * time.Millisecond), ResponseTime: 7 * time.Millisecond},
+		DataPoint{Time: at(2200 * time.Millisecond), ResponseTime: 5 * time.Millisecond},
+	)
+
+	ts.finish(at(2500 * time.Millisecond))
+	points = readTimeSeries(t, &buf)
+
+	if len(points) != 2 {
+		t.Fatalf("expected 2 intervals, got %d", len(points))
+	}
+
+	// the late point is counted in the current interval
+	if p := points[0]; !p.Time.Equal(at(2*time.Second)) || p.QPS != 2 || p.Max != 7*time.Millisecond || p.Errors != 0 || p.NAgents != 4 {
+		t.Errorf("unexpected second interval: %+v", p)
+	}
+
+	// the QPS of the partial interval is per second
+	if p := points[1]; !p.Time.Equal(at(2500*time.Millisecond)) || p.QPS != 2 || p.Max != 5*time.Millisecond || p.NAgents != 4 {
+		t.Errorf("unexpected last interval: %+v", p)
+	}
+}
+
+func TestTimeSeriesCSV(t *testing.T) {
+	t0 := time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	recorder := &Recorder{
+		Started:       t0,
+		HBins:         10,
+		ResponseTimes: []DataPoint{{Time: t0.Add(100 * time.Millisecond), ResponseTime: 1500 * time.Microsecond}},
+		Concurrency:   []ConcurrencyPoint{{Time: t0, NAgents: 1}},
+	}
+
+	var buf bytes.Buffer
+	ts := NewTimeSeries(&buf, TimeSeriesCSV, time.Second)
+	ts.begin(recorder)
+	ts.finish(t0.Add(time.Second))
+
+	expected := "time,qps,p50_ms,p95_ms,p99_ms,max_ms,errors,agents\n" +
+		"2021-01-02T03:04:06Z,1.00,1.500,1.500,1.500,1.500,0,1\n"
+
+	if out := buf.String(); out != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, strings.TrimSpace(out))
+	}
+}
@@ -0,0 +1,327 @@
+package qrn
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgconn"
+	jsoniter "github.com/json-iterator/go"
+)
+
+// TraceBatchSize is the maximum number of spans in an export request.
+const TraceBatchSize = 512
+
+// TraceFlushInterval is the interval to export the spans even if the batch is not full.
+const TraceFlushInterval = 1 * time.Second
+
+// TraceExportTimeout is the timeout of an OTLP/HTTP request.
+const TraceExportTimeout = 10 * time.Second
+
+// Span is a query executed by an agent.
+type Span struct {
+	TraceID     [16]byte
+	SpanID      [8]byte
+	Start       time.Time
+	End         time.Time
+	Statement   string
+	Fingerprint string
+	Agent       int
+	System      string
+	Host        string
+	Route       string
+	Rows        int64
+	Error       string
+	ErrorClass  string
+}
+
+// Tracer exports the spans of queries as OpenTelemetry traces, over OTLP/HTTP (JSON encoding) or to a file.
+// Spans are dropped instead of slowing down the agents when the exporter cannot keep up.
+type Tracer struct {
+	Endpoint   string
+	Out        io.Writer
+	SampleRate float64
+	Comment    bool
+	Token      string
+	Channel    chan *Span
+	Dropped    int64
+	client     *http.Client
+	mu         sync.Mutex
+	err        error
+	done       chan struct{}
+}
+
+type otlpValue struct {
+	StringValue *string `json:"stringValue,omitempty"`
+	IntValue    *string `json:"intValue,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes"`
+	Status            otlpStatus      `json:"status"`
+}
+
+type otlpScopeSpans struct {
+	Scope struct {
+		Name string `json:"name"`
+	} `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpResourceSpans struct {
+	Resource struct {
+		Attributes []otlpAttribute `json:"attributes"`
+	} `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+// NewTracer returns a tracer exporting to the OTLP/HTTP endpoint (e.g. "http://localhost:4318/v1/traces"),
+// or writing export requests as JSON lines to out if it is not nil.
+func NewTracer(endpoint string, out io.Writer, sampleRate float64, comment bool) *Tracer {
+	tracer := &Tracer{
+		Endpoint:   endpoint,
+		Out:        out,
+		SampleRate: sampleRate,
+		Comment:    comment,
+		Channel:    make(chan *Span, TraceBatchSize*4),
+		client:     &http.Client{Timeout: TraceExportTimeout},
+		done:       make(chan struct{}),
+	}
+
+	go tracer.run()
+
+	return tracer
+}
+
+func (tracer *Tracer) run() {
+	ticker := time.NewTicker(TraceFlushInterval)
+	defer ticker.Stop()
+	batch := make([]*Span, 0, TraceBatchSize)
+
+	for {
+		select {
+		case span, ok := <-tracer.Channel:
+			if !ok {
+				tracer.export(batch)
+				close(tracer.done)
+				return
+			}
+
+			batch = append(batch, span)
+
+			if len(batch) >= TraceBatchSize {
+				tracer.export(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			tracer.export(batch)
+			batch = batch[:0]
+		}
+	}
+}
+
+// Sample returns a new span if the query is sampled, or nil.
+func (tracer *Tracer) Sample() *Span {
+	if tracer == nil || rand.Float64() >= tracer.SampleRate {
+		return nil
+	}
+
+	span := &Span{}
+	rand.Read(span.TraceID[:])
+	rand.Read(span.SpanID[:])
+
+	return span
+}
+
+// Annotate appends the trace context to the query as a SQL comment (https://google.github.io/sqlcommenter/),
+// so that database proxies and servers can link their spans to the span of qrn.
+func (span *Span) Annotate(query string) string {
+	comment := fmt.Sprintf("/*traceparent='00-%s-%s-01'*/", hex.EncodeToString(span.TraceID[:]), hex.EncodeToString(span.SpanID[:]))
+	trimmed := strings.TrimRight(query, "; \t\r\n")
+
+	return trimmed + " " + comment + query[len(trimmed):]
+}
+
+func (tracer *Tracer) Add(span *Span) {
+	select {
+	case tracer.Channel <- span:
+	default:
+		atomic.AddInt64(&tracer.Dropped, 1)
+	}
+}
+
+// Close exports the remaining spans and returns the first export error.
+func (tracer *Tracer) Close() error {
+	close(tracer.Channel)
+	<-tracer.done
+
+	tracer.mu.Lock()
+	defer tracer.mu.Unlock()
+
+	return tracer.err
+}
+
+func stringAttr(key string, v string) otlpAttribute {
+	return otlpAttribute{Key: key, Value: otlpValue{StringValue: &v}}
+}
+
+func intAttr(key string, v int64) otlpAttribute {
+	// int64 is encoded as a string in OTLP/JSON
+	s := strconv.FormatInt(v, 10)
+	return otlpAttribute{Key: key, Value: otlpValue{IntValue: &s}}
+}
+
+func (span *Span) otlp() otlpSpan {
+	name := span.Fingerprint
+
+	if i := strings.IndexAny(name, " \t\r\n"); i > 0 {
+		// the operation such as "select"
+		name = name[:i]
+	}
+
+	s := otlpSpan{
+		TraceID:           hex.EncodeToString(span.TraceID[:]),
+		SpanID:            hex.EncodeToString(span.SpanID[:]),
+		Name:              strings.ToUpper(name),
+		Kind:              3, // client
+		StartTimeUnixNano: strconv.FormatInt(span.Start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(span.End.UnixNano(), 10),
+		Attributes: []otlpAttribute{
+			stringAttr("db.system", span.System),
+			stringAttr("db.statement", span.Statement),
+			stringAttr("qrn.fingerprint", span.Fingerprint),
+			intAttr("qrn.agent.id", int64(span.Agent)),
+			intAttr("qrn.rows", span.Rows),
+		},
+	}
+
+	if span.Host != "" {
+		s.Attributes = append(s.Attributes, stringAttr("server.address", span.Host))
+	}
+
+	if span.Route != "" {
+		s.Attributes = append(s.Attributes, stringAttr("qrn.route", span.Route))
+	}
+
+	if span.Error != "" {
+		s.Status = otlpStatus{Code: 2, Message: span.Error}
+		s.Attributes = append(s.Attributes, stringAttr("error.type", span.ErrorClass))
+	}
+
+	return s
+}
+
+func (tracer *Tracer) export(batch []*Span) {
+	if len(batch) == 0 {
+		return
+	}
+
+	rs := otlpResourceSpans{}
+	rs.Resource.Attributes = []otlpAttribute{stringAttr("service.name", "qrn")}
+
+	if tracer.Token != "" {
+		rs.Resource.Attributes = append(rs.Resource.Attributes, stringAttr("qrn.token", tracer.Token))
+	}
+
+	ss := otlpScopeSpans{}
+	ss.Scope.Name = "qrn"
+
+	for _, span := range batch {
+		ss.Spans = append(ss.Spans, span.otlp())
+	}
+
+	rs.ScopeSpans = []otlpScopeSpans{ss}
+	body, _ := jsoniter.Marshal(&otlpRequest{ResourceSpans: []otlpResourceSpans{rs}})
+	var err error
+
+	if tracer.Out != nil {
+		_, err = fmt.Fprintln(tracer.Out, string(body))
+	} else {
+		err = tracer.post(body)
+	}
+
+	if err != nil {
+		tracer.mu.Lock()
+
+		if tracer.err == nil {
+			tracer.err = err
+		}
+
+		tracer.mu.Unlock()
+	}
+}
+
+func (tracer *Tracer) post(body []byte) error {
+	res, err := tracer.client.Post(tracer.Endpoint, "application/json", bytes.NewReader(body))
+
+	if err != nil {
+		return err
+	}
+
+	defer res.Body.Close()
+	io.Copy(ioutil.Discard, res.Body)
+
+	if res.StatusCode/100 != 2 {
+		return fmt.Errorf("OTLP export failed: %s", res.Status)
+	}
+
+	return nil
+}
+
+// dbSystem returns the OpenTelemetry "db.system" of the driver.
+func dbSystem(driver string) string {
+	switch driver {
+	case "mysql", "sqlite":
+		return driver
+	case "pgx":
+		return "postgresql"
+	default:
+		return "other_sql"
+	}
+}
+
+// dsnHost returns the host of the DSN without credentials, or an empty string.
+func dsnHost(driver string, dsn string) string {
+	switch driver {
+	case "mysql":
+		if cfg, err := mysql.ParseDSN(dsn); err == nil {
+			return cfg.Addr
+		}
+	case "pgx":
+		if cfg, err := pgconn.ParseConfig(dsn); err == nil {
+			return fmt.Sprintf("%s:%d", cfg.Host, cfg.Port)
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,198 @@
+package qrn
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+func attrs(attributes []otlpAttribute) map[string]string {
+	m := map[string]string{}
+
+	for _, v := range attributes {
+		if v.Value.StringValue != nil {
+			m[v.Key] = *v.Value.StringValue
+		} else if v.Value.IntValue != nil {
+			m[v.Key] = *v.Value.IntValue
+		}
+	}
+
+	return m
+}
+
+func TestTracerExport(t *testing.T) {
+	var mu sync.Mutex
+	requests := []otlpRequest{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("unexpected request: %s %s", r.Method, r.Header.Get("Content-Type"))
+		}
+
+		body, _ := ioutil.ReadAll(r.Body)
+		req := otlpRequest{}
+
+		if err := jsoniter.Unmarshal(body, &req); err != nil {
+			t.Error(err)
+		}
+
+		mu.Lock()
+		requests = append(requests, req)
+		mu.Unlock()
+	}))
+
+	defer server.Close()
+	tracer := NewTracer(server.URL, nil, 1, false)
+	tracer.Token = "token"
+	start := time.Unix(1600000000, 0)
+
+	for _, errorClass := range []string{"", ErrorClassTimeout} {
+		span := tracer.Sample()
+		span.Start = start
+		span.End = start.Add(time.Millisecond)
+		span.Statement = "select * from users where id = 1"
+		span.Fingerprint = "select * from users where id = ?"
+		span.Agent = 3
+		span.System = "mysql"
+		span.Host = "db:3306"
+		span.Route = RoutePrimary
+		span.Rows = 1
+
+		if errorClass != "" {
+			span.Error = "context deadline exceeded"
+			span.ErrorClass = errorClass
+		}
+
+		tracer.Add(span)
+	}
+
+	err := tracer.Close()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the spans are exported in a batch at Close
+	if len(requests) != 1 || len(requests[0].ResourceSpans) != 1 {
+		t.Fatalf("expected a request, got %d", len(requests))
+	}
+
+	rs := requests[0].ResourceSpans[0]
+
+	if a := attrs(rs.Resource.Attributes); a["service.name"] != "qrn" || a["qrn.token"] != "token" {
+		t.Errorf("unexpected resource attributes: %v", a)
+	}
+
+	spans := rs.ScopeSpans[0].Spans
+
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d", len(spans))
+	}
+
+	s := spans[0]
+
+	if s.Name != "SELECT" || s.Kind != 3 || len(s.TraceID) != 32 || len(s.SpanID) != 16 {
+		t.Errorf("unexpected span: %+v", s)
+	}
+
+	if s.StartTimeUnixNano != "1600000000000000000" || s.EndTimeUnixNano != "1600000000001000000" {
+		t.Errorf("unexpected span time: %s-%s", s.StartTimeUnixNano, s.EndTimeUnixNano)
+	}
+
+	expected := map[string]string{
+		"db.system":       "mysql",
+		"db.statement":    "select * from users where id = 1",
+		"qrn.fingerprint": "select * from users where id = ?",
+		"qrn.agent.id":    "3",
+		"qrn.rows":        "1",
+		"server.address":  "db:3306",
+		"qrn.route":       RoutePrimary,
+	}
+
+	a := attrs(s.Attributes)
+
+	for k, v := range expected {
+		if a[k] != v {
+			t.Errorf("expected %s=%q, got %q", k, v, a[k])
+		}
+	}
+
+	if s.Status.Code != 0 {
+		t.Errorf("expected the unset status, got %+v", s.Status)
+	}
+
+	s = spans[1]
+
+	if s.Status.Code != 2 || s.Status.Message != "context deadline exceeded" || attrs(s.Attributes)["error.type"] != ErrorClassTimeout {
+		t.Errorf("unexpected error span: %+v", s)
+	}
+}
+
+func TestTracerExportError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+
+	defer server.Close()
+	tracer := NewTracer(server.URL, nil, 1, false)
+	tracer.Add(tracer.Sample())
+	err := tracer.Close()
+
+	if err == nil || !strings.Contains(err.Error(), "503") {
+		t.Errorf("expected an export error, got %v", err)
+	}
+}
+
+func TestTracerSample(t *testing.T) {
+	var tracer *Tracer
+
+	if tracer.Sample() != nil {
+		t.Error("expected no span without a tracer")
+	}
+
+	tracer = &Tracer{SampleRate: 0}
+
+	if tracer.Sample() != nil {
+		t.Error("expected no span at sample rate 0")
+	}
+
+	tracer = &Tracer{SampleRate: 1}
+
+	if a, b := tracer.Sample(), tracer.Sample(); a == nil || b == nil || a.TraceID == b.TraceID {
+		t.Error("expected spans with different trace IDs at sample rate 1")
+	}
+}
+
+func TestSpanAnnotate(t *testing.T) {
+	span := &Span{}
+
+	for i := range span.TraceID {
+		span.TraceID[i] = 0xab
+	}
+
+	for i := range span.SpanID {
+		span.SpanID[i] = 0xcd
+	}
+
+	comment := "/*traceparent='00-abababababababababababababababab-cdcdcdcdcdcdcdcd-01'*/"
+
+	tests := []struct {
+		query    string
+		expected string
+	}{
+		{"select 1", "select 1 " + comment},
+		{"select 1;\n", "select 1 " + comment + ";\n"},
+	}
+
+	for _, tt := range tests {
+		if query := span.Annotate(tt.query); query != tt.expected {
+			t.Errorf("expected %q, got %q", tt.expected, query)
+		}
+	}
+}